@@ -16,11 +16,69 @@ type Program struct {
 type Statement struct {
 	Pos lexer.Position
 
+	Decl    *Decl    `parser:"@@ |"`
+	Input   *Input   `parser:"@@ |"`
+	Output  *Output  `parser:"@@ |"`
 	Rule    *Rule    `parser:"@@ |"`
 	Preload *Preload `parser:"(@@ '.') |"`
 	Comment *string  `parser:"@Comment"`
 }
 
+// Decl declares a relation's attributes and how it is stored, for example:
+//
+//	.decl ack(node: location, seq: int) persisted
+//
+// Relations are located (they have location and time attributes) unless declared as edb, in
+// which case they are read-only and replicated to every location.
+type Decl struct {
+	Pos lexer.Position
+
+	Name       string     `parser:"'.' 'decl' @Ident"`
+	Attrs      []DeclAttr `parser:"'(' (@@ (',' @@)*)? ')'"`
+	Qualifiers []string   `parser:"@('edb'|'persisted'|'located')*"`
+}
+
+type DeclAttr struct {
+	Pos lexer.Position
+
+	Name string `parser:"@Ident ':'"`
+	Type string `parser:"@Ident"`
+}
+
+// Input loads a relation's facts from a CSV, TSV or JSON Lines file (chosen by its extension),
+// for example:
+//
+//	.input edge("edges.csv")
+type Input struct {
+	Pos lexer.Position
+
+	Name string `parser:"'.' 'input' @Ident"`
+	Path string `parser:"'(' @String ')'"`
+}
+
+// File returns the path of the input file, without quotes.
+func (i *Input) File() string {
+	// Per the lexer invariants, len(i.Path) >= 2.
+	return i.Path[1 : len(i.Path)-1]
+}
+
+// Output streams every fact derived for a relation to a CSV, TSV or JSON Lines file (chosen by its
+// extension) during execution, for example:
+//
+//	.output path("paths.csv")
+type Output struct {
+	Pos lexer.Position
+
+	Name string `parser:"'.' 'output' @Ident"`
+	Path string `parser:"'(' @String ')'"`
+}
+
+// File returns the path of the output file, without quotes.
+func (o *Output) File() string {
+	// Per the lexer invariants, len(o.Path) >= 2.
+	return o.Path[1 : len(o.Path)-1]
+}
+
 type Rule struct {
 	Pos lexer.Position
 
@@ -106,14 +164,14 @@ type PreloadField struct {
 
 var (
 	lex = lexer.MustSimple([]lexer.Rule{
-		{"Ident", `([a-zA-Z]([a-zA-Z0-9_'])*)|_`, nil},
-		{"Int", `\d+`, nil},
-		{"String", `"(\\"|[^"])*"`, nil},
-		{"Comment", `#[^\n]*`, nil},
-		{"Oper", `:-|!=|>=|<=|[()<>=+*/-]`, nil},
-		{"Delim", `[,.]`, nil},
-		{"EOL", `\\n+`, nil},
-		{"whitespace", `\s+`, nil},
+		{Name: "Ident", Pattern: `([a-zA-Z]([a-zA-Z0-9_'])*)|_`},
+		{Name: "Int", Pattern: `\d+`},
+		{Name: "String", Pattern: `"(\\"|[^"])*"`},
+		{Name: "Comment", Pattern: `#[^\n]*`},
+		{Name: "Oper", Pattern: `:-|!=|>=|<=|[():<>=+*/-]`},
+		{Name: "Delim", Pattern: `[,.]`},
+		{Name: "EOL", Pattern: `\\n+`},
+		{Name: "whitespace", Pattern: `\s+`},
 	})
 
 	parser = participle.MustBuild(&Program{}, participle.Lexer(lex), participle.UseLookahead(3))
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rithvikp/dedalus/engine"
+)
+
+var batchOpts struct {
+	engine.RunOptions
+	dump    []string
+	dumpAll bool
+	format  string
+	output  string
+}
+
+// Exit codes for batch runs. Failing to load or run the program exits with 1.
+const (
+	exitStopped  = 0
+	exitMaxSteps = 2
+)
+
+// batchMode reports whether any stopping condition was given, in which case the program runs
+// without the REPL.
+func batchMode() bool {
+	o := batchOpts.RunOptions
+	return o.Timestep > 0 || o.QuiescentSteps > 0 || o.NonEmpty != "" || o.MaxSteps > 0
+}
+
+// runBatch steps the runner until a stopping condition is met, prints the requested relations and
+// exits. Only the relations are written to stdout (status and errors go to stderr), so they can be
+// parsed by other tools. The exit status is 0 if the condition was met and 2 if the maximum number
+// of steps was reached first.
+func runBatch(r *engine.Runner) {
+	r.SetDerivationLog(nil)
+
+	res, err := r.RunUntil(batchOpts.RunOptions)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to run your program: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Stopped at timestep %d after %d steps: %s\n", r.CurrentTimestamp(), res.Steps, res.Reason)
+
+	if err := dump(r); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to dump relations: %v\n", err)
+		os.Exit(1)
+	}
+	if checkpointOpts.save != "" {
+		if err := saveCheckpoint(r, checkpointOpts.save); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to save the checkpoint: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := r.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to write outputs: %v\n", err)
+		os.Exit(1)
+	}
+
+	if res.Reason == engine.StopMaxSteps {
+		os.Exit(exitMaxSteps)
+	}
+	os.Exit(exitStopped)
+}
+
+// dump writes the relations requested with --dump (or every relation, with --dump-all) in the
+// requested format.
+func dump(r *engine.Runner) error {
+	if len(batchOpts.dump) == 0 && !batchOpts.dumpAll {
+		return nil
+	}
+	format, err := engine.ParseFormat(batchOpts.format)
+	if err != nil {
+		return err
+	}
+
+	w := os.Stdout
+	if batchOpts.output != "" {
+		f, err := os.Create(batchOpts.output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	var names []string
+	if !batchOpts.dumpAll {
+		names = batchOpts.dump
+	}
+	return r.WriteRelations(w, format, names...)
+}
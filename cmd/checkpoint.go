@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/rithvikp/dedalus/engine"
+)
+
+var checkpointOpts struct {
+	// A checkpoint to resume from.
+	restore string
+	// Where to save a checkpoint once a batch run stops.
+	save string
+}
+
+// saveCheckpoint writes the runner's state to the file at path.
+func saveCheckpoint(r *engine.Runner, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := r.Checkpoint(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// restoreCheckpoint replaces the runner's state with the checkpoint in the file at path.
+func restoreCheckpoint(r *engine.Runner, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return r.Restore(f)
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/rithvikp/dedalus/engine"
+)
+
+var schedulerOpts struct {
+	// Empty if every location executes each timestep together.
+	scheduler string
+	maxSkew   int
+}
+
+// configureScheduler gives every location its own clock if a scheduler was requested.
+func configureScheduler(r *engine.Runner) error {
+	if schedulerOpts.maxSkew > 0 && schedulerOpts.scheduler != "random" {
+		return errors.New("--max-skew requires --scheduler random")
+	}
+
+	switch schedulerOpts.scheduler {
+	case "":
+		return nil
+	case "round-robin":
+		return r.SetScheduler(engine.RoundRobinScheduler{})
+	case "random":
+		return r.SetScheduler(engine.RandomScheduler{MaxSkew: schedulerOpts.maxSkew})
+	}
+	return fmt.Errorf("unknown scheduler %q (expected round-robin or random)", schedulerOpts.scheduler)
+}
+
+// printClocks prints the timestep each location executes next.
+func printClocks(r *engine.Runner) {
+	clocks := r.Clocks()
+	if clocks == nil {
+		fmt.Printf("Every location is at timestep %d (locations only have their own clocks with --scheduler)\n", r.CurrentTimestamp())
+		return
+	}
+
+	var locs []string
+	for loc := range clocks {
+		locs = append(locs, loc)
+	}
+	sort.Strings(locs)
+	for _, loc := range locs {
+		fmt.Printf("%s: %d\n", loc, clocks[loc])
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rithvikp/dedalus/ast"
 	"github.com/rithvikp/dedalus/engine"
@@ -22,10 +25,76 @@ var (
 		Run:  run,
 		Args: cobra.ExactArgs(1),
 	}
+
+	checkCmd = &cobra.Command{
+		Use:   "check",
+		Short: "Explore every schedule of async deliveries, searching for invariant violations",
+		Run:   check,
+		Args:  cobra.ExactArgs(1),
+	}
+	checkOpts engine.ExploreOptions
+
+	nodeCmd = &cobra.Command{
+		Use:   "node",
+		Short: "Run a single location of the program, exchanging facts with the rest of the cluster over TCP",
+		Run:   node,
+		Args:  cobra.ExactArgs(1),
+	}
+
+	// Files of facts to load, each of the form REL=PATH.
+	factFiles []string
+	// The number of locations evaluated concurrently.
+	workers int
+	// The number of timesteps the REPL can rewind.
+	history int
 )
 
 func init() {
+	for _, c := range []*cobra.Command{runCmd, checkCmd, nodeCmd} {
+		c.Flags().StringArrayVar(&factFiles, "facts", nil, "load a relation's facts from a CSV, TSV or JSON Lines file: REL=PATH (repeatable)")
+	}
+
+	runCmd.Flags().IntVar(&workers, "workers", 1, "the number of locations to evaluate concurrently within each timestep")
+	runCmd.Flags().IntVar(&history, "history", 100, "the number of most recent timesteps the REPL can rewind to")
+	runCmd.Flags().StringVar(&networkOpts.model, "network", "", "the delay model for async messages: fixed:DELAY, uniform:MIN:MAX or latency:FILE (default uniform:1:8)")
+	runCmd.Flags().Int64Var(&networkOpts.seed, "seed", 0, "the seed for all randomness in the network model")
+	runCmd.Flags().Float64Var(&networkOpts.dropRate, "drop-rate", 0, "the probability that an async message is dropped")
+	runCmd.Flags().Float64Var(&networkOpts.duplicateRate, "duplicate-rate", 0, "the probability that an async message is duplicated")
+	runCmd.Flags().StringSliceVar(&faultOpts.crashes, "crash", nil, "crash a location, optionally restarting it with its persisted relations: LOC@CRASH[-RESTART] (repeatable)")
+	runCmd.Flags().StringSliceVar(&faultOpts.amnesiaCrashes, "amnesia-crash", nil, "like --crash, but the location restarts with no state (repeatable)")
+	runCmd.Flags().StringVar(&faultOpts.file, "faults", "", "a fault schedule file, where each line is \"LOC CRASH [RESTART] [amnesia]\"")
+	runCmd.Flags().StringArrayVar(&faultOpts.partitions, "partition", nil, "partition the locations into groups between two timesteps: START[-END]:A,B|C,D (repeatable)")
+	runCmd.Flags().StringVar(&faultOpts.partitionMode, "partition-mode", "drop", "what happens to facts sent across a partition: drop or hold (until it heals)")
+	runCmd.Flags().StringVar(&schedulerOpts.scheduler, "scheduler", "", "give every location its own clock, stepping one location at a time: round-robin or random")
+	runCmd.Flags().IntVar(&schedulerOpts.maxSkew, "max-skew", 0, "with --scheduler random, the most timesteps a location can run ahead of the slowest one (0 for no limit)")
+	runCmd.Flags().StringVar(&checkpointOpts.restore, "restore", "", "resume from a checkpoint taken with the same program (the network model must be given again)")
+	runCmd.Flags().StringVar(&checkpointOpts.save, "checkpoint", "", "save a checkpoint to this file once a batch run stops")
+	runCmd.Flags().IntVar(&batchOpts.Timestep, "until", 0, "run without the REPL until this timestep")
+	runCmd.Flags().IntVar(&batchOpts.QuiescentSteps, "until-quiescent", 0, "run without the REPL until no relation changes for this many consecutive timesteps")
+	runCmd.Flags().StringVar(&batchOpts.NonEmpty, "until-nonempty", "", "run without the REPL until this relation contains a fact")
+	runCmd.Flags().IntVar(&batchOpts.MaxSteps, "max-steps", 0, "run without the REPL for at most this many timesteps, exiting with status 2 if no other condition is met first")
+	runCmd.Flags().StringSliceVar(&batchOpts.dump, "dump", nil, "a relation to print once a batch run stops (repeatable)")
+	runCmd.Flags().BoolVar(&batchOpts.dumpAll, "dump-all", false, "print every relation once a batch run stops")
+	runCmd.Flags().StringVar(&batchOpts.format, "format", "table", "the format of dumped relations: table, json, jsonl, csv or tsv")
+	runCmd.Flags().StringVarP(&batchOpts.output, "output", "o", "", "write dumped relations to this file instead of stdout")
 	rootCmd.AddCommand(runCmd)
+
+	checkCmd.Flags().IntVar(&checkOpts.MaxDelay, "max-delay", 3, "the maximum number of timesteps an async message can be delayed by")
+	checkCmd.Flags().IntVar(&checkOpts.MaxSteps, "max-steps", 10, "the number of timesteps to execute along every schedule")
+	checkCmd.Flags().IntVar(&checkOpts.MaxStates, "max-states", 0, "stop after exploring this many states (0 for no limit)")
+	checkCmd.Flags().IntVar(&checkOpts.MaxSchedules, "max-schedules", engine.DefaultMaxSchedules, "stop after trying this many combinations of delays")
+	checkCmd.Flags().StringSliceVar(&checkOpts.Invariants, "invariant", nil, "a relation which must never contain a fact (repeatable)")
+	rootCmd.AddCommand(checkCmd)
+
+	nodeCmd.Flags().StringVar(&nodeOpts.location, "location", "", "the location to run")
+	nodeCmd.Flags().StringVar(&nodeOpts.cluster, "cluster", "", "a cluster config file, where each line is \"LOC HOST:PORT\"")
+	nodeCmd.Flags().DurationVar(&nodeOpts.tick, "tick", 100*time.Millisecond, "how often to execute a timestep")
+	nodeCmd.Flags().IntVar(&nodeOpts.steps, "steps", 0, "stop after executing this many timesteps (0 to run until interrupted)")
+	nodeCmd.Flags().StringSliceVar(&batchOpts.dump, "dump", nil, "a relation to print once the node stops (repeatable)")
+	nodeCmd.Flags().BoolVar(&batchOpts.dumpAll, "dump-all", false, "print every relation once the node stops")
+	nodeCmd.Flags().StringVar(&batchOpts.format, "format", "table", "the format of dumped relations: table, json, jsonl, csv or tsv")
+	nodeCmd.Flags().StringVarP(&batchOpts.output, "output", "o", "", "write dumped relations to this file instead of stdout")
+	rootCmd.AddCommand(nodeCmd)
 }
 
 // Execute starts the program.
@@ -33,25 +102,126 @@ func Execute() error {
 	return rootCmd.Execute()
 }
 
-func run(cmd *cobra.Command, args []string) {
-	f, err := os.Open(args[0])
+// newRunner parses the given source file and initializes a runner for it, exiting on failure.
+func newRunner(path string) *engine.Runner {
+	f, err := os.Open(path)
 	if err != nil {
-		fmt.Printf("Unable to read the source file: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Unable to read the source file: %v\n", err)
 		os.Exit(1)
 	}
 
 	p, err := ast.Parse(f)
 	if err != nil {
-		fmt.Printf("Unable to parse your program: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Unable to parse your program: %v\n", err)
 		os.Exit(1)
 	}
 
+	// Input and output files are relative to the program.
+	for _, st := range p.Statements {
+		if st.Input != nil && !filepath.IsAbs(st.Input.File()) {
+			st.Input.Path = `"` + filepath.Join(filepath.Dir(path), st.Input.File()) + `"`
+		}
+		if st.Output != nil && !filepath.IsAbs(st.Output.File()) {
+			st.Output.Path = `"` + filepath.Join(filepath.Dir(path), st.Output.File()) + `"`
+		}
+	}
+
 	r, err := engine.NewRunner(p)
 	if err != nil {
-		fmt.Printf("Unable to run your program: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Unable to run your program: %v\n", err)
 		os.Exit(1)
 	}
 
+	for _, spec := range factFiles {
+		rel, file, ok := strings.Cut(spec, "=")
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unable to load facts: %q must be of the form REL=PATH\n", spec)
+			os.Exit(1)
+		}
+		if err := r.LoadFacts(rel, file); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to load facts into %s: %v\n", rel, err)
+			os.Exit(1)
+		}
+	}
+	return r
+}
+
+// Exit codes for check. Failing to load or explore the program exits with 1.
+const (
+	exitNoViolation = 0
+	exitViolation   = 2
+	// No violation was found, but the search stopped at --max-states or --max-schedules.
+	exitTruncated = 3
+)
+
+// check explores the program's schedules and exits with 0 if no invariant is violated, 2 if one is
+// and 3 if the search was cut short before finding a violation.
+func check(cmd *cobra.Command, args []string) {
+	r := newRunner(args[0])
+	r.SetDerivationLog(nil)
+
+	res, err := r.Explore(checkOpts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to explore your program: %v\n", err)
+		os.Exit(1)
+	}
+
+	if res.Violation == nil {
+		if res.Truncated {
+			fmt.Printf("No invariant violations found after exploring %d states (%d schedules), but exploration stopped early as the maximum number of states or schedules was reached\n", res.States, res.Schedules)
+			os.Exit(exitTruncated)
+		}
+		fmt.Printf("No invariant violations found after exploring %d states (%d schedules)\n", res.States, res.Schedules)
+		os.Exit(exitNoViolation)
+	}
+
+	v := res.Violation
+	fmt.Printf("Invariant %q violated at timestep %d after exploring %d states:\n", v.Relation, v.Timestamp, res.States)
+	for _, f := range v.Facts {
+		fmt.Printf("  %s\n", strings.Join(f, ", "))
+	}
+	fmt.Println("Trace:")
+	for _, step := range v.Trace {
+		fmt.Printf("  Timestep %d\n", step.Timestamp)
+		for _, d := range step.Deliveries {
+			fmt.Printf("    %s\n", d)
+		}
+	}
+	os.Exit(exitViolation)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	r := newRunner(args[0])
+	r.SetWorkers(workers)
+	if err := configureNetwork(r); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to configure the network: %v\n", err)
+		os.Exit(1)
+	}
+	if err := configureScheduler(r); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to configure the scheduler: %v\n", err)
+		os.Exit(1)
+	}
+	if checkpointOpts.restore != "" {
+		if err := restoreCheckpoint(r, checkpointOpts.restore); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to restore the checkpoint: %v\n", err)
+			os.Exit(1)
+		}
+		// The checkpoint's seed is kept unless another is given.
+		if cmd.Flags().Changed("seed") {
+			r.SetSeed(networkOpts.seed)
+		}
+	}
+	if err := configureFaults(r); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to schedule faults: %v\n", err)
+		os.Exit(1)
+	}
+
+	if batchMode() {
+		runBatch(r)
+		return
+	}
+
+	r.SetHistory(history)
 	fmt.Println("<=== Ready to begin execution ===>")
 
 	scanner := bufio.NewScanner(os.Stdin)
@@ -65,7 +235,14 @@ func run(cmd *cobra.Command, args []string) {
 
 		switch tokens[0] {
 		case "s", "step":
-			r.Step()
+			if len(tokens) == 1 {
+				r.Step()
+			} else if err := r.StepLocation(tokens[1]); err != nil {
+				fmt.Printf("Unable to step %s: %v\n", tokens[1], err)
+			}
+
+		case "clocks":
+			printClocks(r)
 
 		case "p", "print":
 			if len(tokens) != 2 {
@@ -80,8 +257,79 @@ func run(cmd *cobra.Command, args []string) {
 			}
 			fmt.Println()
 
+		case "w", "why":
+			why(r, strings.TrimPrefix(in, tokens[0]))
+
+		case "wn", "why-not":
+			whyNot(r, strings.TrimPrefix(in, tokens[0]))
+
+		case "d", "dump":
+			if len(tokens) < 2 {
+				fmt.Println("The dump command requires a format (table, json, jsonl, csv or tsv), optionally followed by the relations to dump")
+				continue
+			}
+			format, err := engine.ParseFormat(tokens[1])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			if err := r.WriteRelations(os.Stdout, format, tokens[2:]...); err != nil {
+				fmt.Printf("Unable to dump relations: %v\n", err)
+			}
+
+		case "q", "query":
+			query(r, strings.TrimPrefix(in, tokens[0]))
+
+		case "i", "insert":
+			insert(r, strings.TrimPrefix(in, tokens[0]), r.CurrentTimestamp())
+
+		case "insert-at":
+			if len(tokens) < 3 {
+				fmt.Println("The insert-at command requires a timestep and a fact, as in insert-at 5 rel(\"a\")@LOC")
+				continue
+			}
+			time, err := strconv.Atoi(tokens[1])
+			if err != nil {
+				fmt.Printf("%q is not a valid timestep\n", tokens[1])
+				continue
+			}
+			insert(r, strings.Join(tokens[2:], " "), time)
+
+		case "at":
+			view(r, tokens[1:])
+
+		case "diff":
+			diff(r, tokens[1:])
+
+		case "rewind":
+			rewind(r, tokens[1:])
+
+		case "watch":
+			watch(r, strings.TrimPrefix(in, tokens[0]))
+
+		case "b", "break":
+			breakOn(r, tokens[1:])
+
+		case "breakpoints":
+			listBreakpoints(r)
+
+		case "delete":
+			deleteBreakpoint(r, tokens[1:])
+
+		case "c", "continue":
+			continueExecution(r, tokens[1:])
+
+		case "checkpoint":
+			if len(tokens) != 2 {
+				fmt.Println("The checkpoint command requires one additional argument: the file to save the checkpoint to")
+				continue
+			}
+			if err := saveCheckpoint(r, tokens[1]); err != nil {
+				fmt.Printf("Unable to save the checkpoint: %v\n", err)
+			}
+
 		case "h", "help":
-			fmt.Println("TODO: help page")
+			printHelp()
 
 		}
 
@@ -90,4 +338,8 @@ func run(cmd *cobra.Command, args []string) {
 	if err := scanner.Err(); err != nil {
 		fmt.Printf("Unable to read input: %v\n", err)
 	}
+	if err := r.Close(); err != nil {
+		fmt.Printf("Unable to write outputs: %v\n", err)
+		os.Exit(1)
+	}
 }
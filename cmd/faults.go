@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rithvikp/dedalus/engine"
+)
+
+var faultOpts struct {
+	crashes        []string
+	amnesiaCrashes []string
+	file           string
+	partitions     []string
+	partitionMode  string
+}
+
+// parseCrash parses a crash of the form LOC@CRASH or LOC@CRASH-RESTART.
+func parseCrash(spec string, amnesia bool) (engine.Fault, error) {
+	loc, times, ok := strings.Cut(spec, "@")
+	if !ok || loc == "" {
+		return engine.Fault{}, fmt.Errorf("%q is not of the form LOC@CRASH[-RESTART]", spec)
+	}
+
+	f := engine.Fault{Location: loc, Amnesia: amnesia}
+	crash, restart, hasRestart := strings.Cut(times, "-")
+	var err error
+	if f.CrashAt, err = strconv.Atoi(crash); err != nil {
+		return engine.Fault{}, fmt.Errorf("%q is not a valid timestep", crash)
+	}
+	if hasRestart {
+		if f.RestartAt, err = strconv.Atoi(restart); err != nil {
+			return engine.Fault{}, fmt.Errorf("%q is not a valid timestep", restart)
+		}
+	}
+	return f, nil
+}
+
+// parsePartition parses a partition of the form START[-END]:GROUP|GROUP|..., where each group is a
+// comma-separated list of locations.
+func parsePartition(spec string, mode engine.PartitionMode) (engine.Partition, error) {
+	times, groups, ok := strings.Cut(spec, ":")
+	if !ok || groups == "" {
+		return engine.Partition{}, fmt.Errorf("%q is not of the form START[-END]:GROUP|GROUP|...", spec)
+	}
+
+	p := engine.Partition{Mode: mode}
+	start, end, hasEnd := strings.Cut(times, "-")
+	var err error
+	if p.Start, err = strconv.Atoi(start); err != nil {
+		return engine.Partition{}, fmt.Errorf("%q is not a valid timestep", start)
+	}
+	if hasEnd {
+		if p.End, err = strconv.Atoi(end); err != nil {
+			return engine.Partition{}, fmt.Errorf("%q is not a valid timestep", end)
+		}
+	}
+
+	for _, g := range strings.Split(groups, "|") {
+		p.Groups = append(p.Groups, strings.Split(g, ","))
+	}
+	return p, nil
+}
+
+// parseFaultFile reads a fault schedule, where each line is "LOC CRASH [RESTART] [amnesia]" and
+// lines starting with # are comments.
+func parseFaultFile(path string) ([]engine.Fault, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var faults []engine.Fault
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		f := engine.Fault{Location: fields[0]}
+		if len(fields) > 1 && fields[len(fields)-1] == "amnesia" {
+			f.Amnesia = true
+			fields = fields[:len(fields)-1]
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: expected \"LOC CRASH [RESTART] [amnesia]\"", path, line)
+		}
+		if f.CrashAt, err = strconv.Atoi(fields[1]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %q is not a valid timestep", path, line, fields[1])
+		}
+		if len(fields) == 3 {
+			if f.RestartAt, err = strconv.Atoi(fields[2]); err != nil {
+				return nil, fmt.Errorf("%s:%d: %q is not a valid timestep", path, line, fields[2])
+			}
+		}
+		faults = append(faults, f)
+	}
+	return faults, scanner.Err()
+}
+
+// configureFaults schedules every fault from the command-line flags on the runner.
+func configureFaults(r *engine.Runner) error {
+	var faults []engine.Fault
+	for _, specs := range []struct {
+		specs   []string
+		amnesia bool
+	}{{faultOpts.crashes, false}, {faultOpts.amnesiaCrashes, true}} {
+		for _, spec := range specs.specs {
+			f, err := parseCrash(spec, specs.amnesia)
+			if err != nil {
+				return err
+			}
+			faults = append(faults, f)
+		}
+	}
+
+	if faultOpts.file != "" {
+		fileFaults, err := parseFaultFile(faultOpts.file)
+		if err != nil {
+			return err
+		}
+		faults = append(faults, fileFaults...)
+	}
+
+	for _, f := range faults {
+		if err := r.AddFault(f); err != nil {
+			return err
+		}
+	}
+
+	var mode engine.PartitionMode
+	switch faultOpts.partitionMode {
+	case "drop":
+		mode = engine.PartitionDrop
+	case "hold":
+		mode = engine.PartitionHold
+	default:
+		return fmt.Errorf("unknown partition mode %q", faultOpts.partitionMode)
+	}
+	for _, spec := range faultOpts.partitions {
+		p, err := parsePartition(spec, mode)
+		if err != nil {
+			return err
+		}
+		if err := r.AddPartition(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rithvikp/dedalus/engine"
+)
+
+var networkOpts struct {
+	model         string
+	seed          int64
+	dropRate      float64
+	duplicateRate float64
+}
+
+// parseNetworkModel builds a network model from its command-line specification, which is one of:
+//
+//	fixed:DELAY
+//	uniform:MIN:MAX
+//	latency:FILE (each line of the file is "FROM TO DELAY", and other links have a delay of 1)
+func parseNetworkModel(spec string) (engine.NetworkModel, error) {
+	parts := strings.Split(spec, ":")
+	ints := func(n int) ([]int, error) {
+		if len(parts)-1 != n {
+			return nil, fmt.Errorf("%q requires %d argument(s)", parts[0], n)
+		}
+		vals := make([]int, n)
+		for i := range vals {
+			v, err := strconv.Atoi(parts[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a valid delay", parts[i+1])
+			}
+			vals[i] = v
+		}
+		return vals, nil
+	}
+
+	switch parts[0] {
+	case "fixed":
+		vals, err := ints(1)
+		if err != nil {
+			return nil, err
+		}
+		return engine.FixedDelay(vals[0]), nil
+
+	case "uniform":
+		vals, err := ints(2)
+		if err != nil {
+			return nil, err
+		}
+		return engine.UniformDelay{Min: vals[0], Max: vals[1]}, nil
+
+	case "latency":
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%q requires a file", parts[0])
+		}
+		return parseLatencyFile(parts[1])
+	}
+
+	return nil, fmt.Errorf("unknown network model %q", parts[0])
+}
+
+func parseLatencyFile(path string) (engine.NetworkModel, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := engine.LatencyMatrix{Latencies: map[engine.Link]int{}, Default: engine.FixedDelay(1)}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		} else if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected \"FROM TO DELAY\"", path, line)
+		}
+
+		d, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %q is not a valid delay", path, line, fields[2])
+		}
+		m.Latencies[engine.Link{From: fields[0], To: fields[1]}] = d
+	}
+	return m, scanner.Err()
+}
+
+// configureNetwork applies the network flags to the runner.
+func configureNetwork(r *engine.Runner) error {
+	r.SetSeed(networkOpts.seed)
+	if networkOpts.model == "" && networkOpts.dropRate == 0 && networkOpts.duplicateRate == 0 {
+		return nil
+	}
+
+	var m engine.NetworkModel = engine.UniformDelay{Min: 1, Max: 8}
+	if networkOpts.model != "" {
+		var err error
+		if m, err = parseNetworkModel(networkOpts.model); err != nil {
+			return err
+		}
+	}
+	if networkOpts.dropRate > 0 || networkOpts.duplicateRate > 0 {
+		m = engine.LossyLink{Model: m, DropRate: networkOpts.dropRate, DuplicateRate: networkOpts.duplicateRate}
+	}
+	r.SetNetworkModel(m)
+	return nil
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/rithvikp/dedalus/engine"
+	"github.com/spf13/cobra"
+)
+
+var nodeOpts struct {
+	location string
+	cluster  string
+	tick     time.Duration
+	steps    int
+}
+
+// parseClusterFile reads a cluster config, where each line is "LOC HOST:PORT" and lines starting
+// with # are comments.
+func parseClusterFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	cluster := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"LOC HOST:PORT\"", path, line)
+		}
+		if _, ok := cluster[fields[0]]; ok {
+			return nil, fmt.Errorf("%s:%d: %q is listed more than once", path, line, fields[0])
+		}
+		cluster[fields[0]] = fields[1]
+	}
+	return cluster, scanner.Err()
+}
+
+// node runs a single location of the program, exchanging facts with the other locations in the
+// cluster over TCP, until it has executed the requested number of steps or is interrupted.
+func node(cmd *cobra.Command, args []string) {
+	if nodeOpts.location == "" || nodeOpts.cluster == "" {
+		fmt.Fprintln(os.Stderr, "The node command requires --location and --cluster")
+		os.Exit(1)
+	}
+	cluster, err := parseClusterFile(nodeOpts.cluster)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read the cluster config: %v\n", err)
+		os.Exit(1)
+	}
+
+	r := newRunner(args[0])
+	// Only dumped relations are written to stdout (see runBatch).
+	r.SetDerivationLog(os.Stderr)
+	n, err := engine.NewNode(r, nodeOpts.location, cluster)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to start the node: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "<=== Running %s on %s ===>\n", nodeOpts.location, n.Addr())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	runErr := n.Run(ctx, nodeOpts.tick, nodeOpts.steps)
+	if err := n.Close(); err != nil && runErr == nil {
+		runErr = err
+	}
+	if runErr != nil {
+		fmt.Fprintf(os.Stderr, "Unable to run the node: %v\n", runErr)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Stopped at timestep %d\n", r.CurrentTimestamp())
+
+	if err := dump(r); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to dump relations: %v\n", err)
+		os.Exit(1)
+	}
+	if err := r.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to write outputs: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,420 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rithvikp/dedalus/ast"
+	"github.com/rithvikp/dedalus/engine"
+)
+
+// replFact is a fact written in the REPL using the same syntax as facts in a program, such as
+// path("a","b",L1,0) (the location and time are omitted for read-only relations).
+type replFact struct {
+	relation string
+	tuple    []string
+	loc      string
+	time     int
+}
+
+func parseReplFact(s string) (*replFact, error) {
+	s = strings.TrimSuffix(strings.TrimSpace(s), ".")
+	p, err := ast.Parse(strings.NewReader(s + "."))
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Statements) != 1 || p.Statements[0].Preload == nil {
+		return nil, errors.New("expected a single fact, such as rel(\"a\",\"b\",L1,0)")
+	}
+
+	preload := p.Statements[0].Preload
+	f := &replFact{relation: preload.Name}
+	for _, field := range preload.Fields {
+		f.tuple = append(f.tuple, field.Data[1:len(field.Data)-1])
+	}
+	if preload.Time != nil {
+		f.loc = *preload.Loc
+		f.time = *preload.Time
+	}
+	return f, nil
+}
+
+// why prints the derivation of the given fact.
+func why(r *engine.Runner, arg string) {
+	f, err := parseReplFact(arg)
+	if err != nil {
+		fmt.Printf("Unable to parse the fact: %v\n", err)
+		return
+	}
+
+	d, err := r.Why(f.relation, f.tuple, f.loc, f.time)
+	if err != nil {
+		fmt.Printf("Unable to explain the fact: %v\n", err)
+		return
+	}
+	fmt.Print(d)
+}
+
+// bareWildcard matches unquoted _ attributes in a fact pattern.
+var bareWildcard = regexp.MustCompile(`([(,]\s*)_(\s*[,)])`)
+
+// quoteWildcards quotes the wildcards in a pattern so it parses as a fact.
+func quoteWildcards(s string) string {
+	// Replace twice, since adjacent matches overlap.
+	return bareWildcard.ReplaceAllString(bareWildcard.ReplaceAllString(s, `$1"_"$2`), `$1"_"$2`)
+}
+
+// whyNot prints why no fact matching the given pattern holds, where _ matches any attribute.
+func whyNot(r *engine.Runner, arg string) {
+	f, err := parseReplFact(quoteWildcards(arg))
+	if err != nil {
+		fmt.Printf("Unable to parse the pattern: %v\n", err)
+		return
+	}
+
+	e, err := r.WhyNot(f.relation, f.tuple, f.loc, f.time)
+	if err != nil {
+		fmt.Printf("Unable to explain the missing fact: %v\n", err)
+		return
+	}
+	fmt.Print(e)
+}
+
+// splitLocation splits a fact written as rel("a")@LOC into the fact and its location (which is empty
+// if it is not given).
+func splitLocation(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndex(s, ")"); i >= 0 {
+		if rest := strings.TrimSpace(s[i+1:]); strings.HasPrefix(rest, "@") {
+			return s[:i+1], strings.TrimSpace(rest[1:])
+		}
+	}
+	return s, ""
+}
+
+// insert adds a fact, written as rel("a","b")@LOC (without the location for read-only relations),
+// at the given timestep.
+func insert(r *engine.Runner, arg string, time int) {
+	arg, loc := splitLocation(arg)
+	f, err := parseReplFact(arg)
+	if err != nil {
+		fmt.Printf("Unable to parse the fact: %v\n", err)
+		return
+	}
+	if f.loc != "" {
+		fmt.Println("The location must be given after the fact, as in rel(\"a\")@LOC")
+		return
+	}
+
+	added, err := r.Insert(f.relation, f.tuple, loc, time)
+	if err != nil {
+		fmt.Printf("Unable to insert the fact: %v\n", err)
+		return
+	}
+	if !added {
+		fmt.Println("The fact already holds")
+	}
+}
+
+// query prints the bindings which satisfy a rule body, optionally followed by @ LOC TIME to
+// restrict it to a location and timestep, where _ matches any location or timestep.
+func query(r *engine.Runner, arg string) {
+	loc, time := "", -1
+	if i := strings.LastIndex(arg, "@"); i >= 0 && i > strings.LastIndex(arg, ")") {
+		where := strings.Fields(arg[i+1:])
+		if len(where) == 0 || len(where) > 2 {
+			fmt.Println("The query must end with @ LOC or @ LOC TIME")
+			return
+		}
+		if where[0] != "_" {
+			loc = where[0]
+		}
+		if len(where) == 2 && where[1] != "_" {
+			t, err := strconv.Atoi(where[1])
+			if err != nil || t < 0 {
+				fmt.Printf("%q is not a valid timestep\n", where[1])
+				return
+			}
+			time = t
+		}
+		arg = arg[:i]
+	}
+
+	res, err := r.QueryBody(strings.TrimSpace(arg), loc, time)
+	if err != nil {
+		fmt.Printf("Unable to evaluate the query: %v\n", err)
+		return
+	}
+
+	tabw := new(tabwriter.Writer)
+	tabw.Init(os.Stdout, 4, 8, 1, ' ', 0)
+	fmt.Fprintln(tabw, strings.Join(res.Columns, "\t"))
+	for _, row := range res.Rows {
+		fmt.Fprintln(tabw, strings.Join(row, "\t"))
+	}
+	tabw.Flush()
+	fmt.Printf("(%d rows)\n", len(res.Rows))
+}
+
+// formatFact renders a fact as in derivations, such as path(a, c) @ L1.
+func formatFact(f engine.Fact) string {
+	return fmt.Sprintf("%s(%s) @ %s", f.Relation, strings.Join(f.Tuple, ", "), f.Location)
+}
+
+// parseTimestep parses a non-negative timestep given to a REPL command.
+func parseTimestep(s string) (int, error) {
+	t, err := strconv.Atoi(s)
+	if err != nil || t < 0 {
+		return 0, fmt.Errorf("%q is not a valid timestep", s)
+	}
+	return t, nil
+}
+
+// view prints every fact at a timestep, optionally at a single location: at T [LOC].
+func view(r *engine.Runner, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Println("The at command requires a timestep, optionally followed by a location")
+		return
+	}
+	time, err := parseTimestep(args[0])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	var loc string
+	if len(args) == 2 {
+		loc = args[1]
+	}
+
+	facts := r.FactsAt(loc, time)
+	for _, f := range facts {
+		fmt.Println(formatFact(f))
+	}
+	fmt.Printf("(%d facts at timestep %d)\n", len(facts), time)
+}
+
+// diff prints the facts added and removed between two timesteps, optionally at a single location:
+// diff FROM TO [LOC].
+func diff(r *engine.Runner, args []string) {
+	if len(args) < 2 || len(args) > 3 {
+		fmt.Println("The diff command requires two timesteps, optionally followed by a location")
+		return
+	}
+	from, err := parseTimestep(args[0])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	to, err := parseTimestep(args[1])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	var loc string
+	if len(args) == 3 {
+		loc = args[2]
+	}
+
+	diffs := r.Diff(loc, from, to)
+	if len(diffs) == 0 {
+		fmt.Printf("No changes between timesteps %d and %d\n", from, to)
+	}
+	for _, d := range diffs {
+		fmt.Printf("%s:\n", d.Relation)
+		for _, f := range d.Added {
+			fmt.Printf("  + %s\n", formatFact(f))
+		}
+		for _, f := range d.Removed {
+			fmt.Printf("  - %s\n", formatFact(f))
+		}
+	}
+}
+
+// rewind returns the runner to the start of an earlier timestep, optionally changing its seed:
+// rewind T [SEED].
+func rewind(r *engine.Runner, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Println("The rewind command requires a timestep, optionally followed by a new seed")
+		return
+	}
+	time, err := parseTimestep(args[0])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	var seed int64
+	if len(args) == 2 {
+		if seed, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+			fmt.Printf("%q is not a valid seed\n", args[1])
+			return
+		}
+	}
+
+	if err := r.Rewind(time); err != nil {
+		fmt.Printf("Unable to rewind: %v\n", err)
+		return
+	}
+	if len(args) == 2 {
+		r.SetSeed(seed)
+	}
+	fmt.Printf("Rewound to timestep %d\n", time)
+}
+
+// defaultContinueSteps bounds continue in the REPL, so it returns if no breakpoint is ever hit.
+const defaultContinueSteps = 1000
+
+// watch sets a breakpoint on a relation receiving a fact matching a pattern, written as
+// rel("a",_)@LOC, where the pattern and location are optional.
+func watch(r *engine.Runner, arg string) {
+	arg, loc := splitLocation(arg)
+	b := engine.Breakpoint{Kind: engine.BreakOnFact, Relation: arg, Location: loc}
+	if strings.Contains(arg, "(") {
+		f, err := parseReplFact(quoteWildcards(arg))
+		if err != nil {
+			fmt.Printf("Unable to parse the pattern: %v\n", err)
+			return
+		}
+		if f.loc != "" {
+			fmt.Println("The location must be given after the pattern, as in rel(\"a\",_)@LOC")
+			return
+		}
+		b.Relation, b.Pattern = f.relation, f.tuple
+	}
+	addBreakpoint(r, b)
+}
+
+// breakOn sets a breakpoint on a rule or on messages: break rule ID, break line N or
+// break message [LOC].
+func breakOn(r *engine.Runner, args []string) {
+	usage := "The break command requires one of: rule ID, line N or message [LOC]"
+	if len(args) == 0 {
+		fmt.Println(usage)
+		return
+	}
+
+	switch {
+	case args[0] == "rule" && len(args) == 2:
+		addBreakpoint(r, engine.Breakpoint{Kind: engine.BreakOnRule, Rule: args[1]})
+	case args[0] == "line" && len(args) == 2:
+		line, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Printf("%q is not a valid line\n", args[1])
+			return
+		}
+		addBreakpoint(r, engine.Breakpoint{Kind: engine.BreakOnRule, Line: line})
+	case args[0] == "message" && len(args) <= 2:
+		b := engine.Breakpoint{Kind: engine.BreakOnMessage}
+		if len(args) == 2 {
+			b.Location = args[1]
+		}
+		addBreakpoint(r, b)
+	default:
+		fmt.Println(usage)
+	}
+}
+
+func addBreakpoint(r *engine.Runner, b engine.Breakpoint) {
+	id, err := r.AddBreakpoint(b)
+	if err != nil {
+		fmt.Printf("Unable to set the breakpoint: %v\n", err)
+		return
+	}
+	b.ID = id
+	fmt.Printf("Breakpoint %d: %s\n", id, b)
+}
+
+// listBreakpoints prints every breakpoint.
+func listBreakpoints(r *engine.Runner) {
+	bs := r.Breakpoints()
+	if len(bs) == 0 {
+		fmt.Println("No breakpoints are set")
+	}
+	for _, b := range bs {
+		fmt.Printf("Breakpoint %d: %s\n", b.ID, b)
+	}
+}
+
+// deleteBreakpoint removes a breakpoint by its ID.
+func deleteBreakpoint(r *engine.Runner, args []string) {
+	if len(args) != 1 {
+		fmt.Println("The delete command requires one additional argument: the ID of the breakpoint")
+		return
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		fmt.Printf("%q is not a valid breakpoint\n", args[0])
+		return
+	}
+	if err := r.RemoveBreakpoint(id); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// continueExecution steps until a breakpoint is hit, for at most the given number of steps
+// (defaultContinueSteps if it is omitted), and prints the derivations which hit it.
+func continueExecution(r *engine.Runner, args []string) {
+	maxSteps := defaultContinueSteps
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			fmt.Printf("%q is not a valid number of steps\n", args[0])
+			return
+		}
+		maxSteps = n
+	} else if len(args) > 1 {
+		fmt.Println("The continue command takes at most one argument: the maximum number of steps")
+		return
+	}
+
+	hits, err := r.Continue(maxSteps)
+	if err != nil {
+		fmt.Printf("Unable to continue: %v\n", err)
+		return
+	}
+	if len(hits) == 0 {
+		fmt.Printf("No breakpoints were hit after %d steps\n", maxSteps)
+		return
+	}
+	fmt.Printf("Stopped at timestep %d\n", r.CurrentTimestamp())
+	for _, h := range hits {
+		fmt.Print(h)
+	}
+}
+
+// replCommands lists each REPL command with its arguments and a description, for help.
+var replCommands = [][3]string{
+	{"s, step", "[LOC]", "evaluate the next timestep (at LOC, if every location has its own clock)"},
+	{"clocks", "", "print the timestep of every location's clock"},
+	{"p, print", "REL", "print a relation as a table"},
+	{"d, dump", "FORMAT [REL...]", "write relations as a table, json, jsonl, csv or tsv (every relation by default)"},
+	{"q, query", "BODY [@ LOC [TIME]]", "print the bindings which satisfy a rule body, where _ matches any location or time"},
+	{"w, why", "FACT", "print the derivation of a fact, such as path(\"a\",\"c\",L1,0)"},
+	{"wn, why-not", "PATTERN", "explain why no fact matching a pattern holds, where _ matches any attribute"},
+	{"i, insert", "FACT[@LOC]", "add a fact at the current timestep, such as edge(\"a\",\"b\")@L1"},
+	{"insert-at", "T FACT[@LOC]", "add a fact at timestep T"},
+	{"at", "T [LOC]", "print every fact at a timestep"},
+	{"diff", "FROM TO [LOC]", "print the facts added and removed between two timesteps"},
+	{"rewind", "T [SEED]", "return to the start of an earlier timestep, optionally with a new seed"},
+	{"watch", "REL[(PATTERN)][@LOC]", "break when a relation receives a fact matching a pattern"},
+	{"b, break", "rule ID | line N | message [LOC]", "break when a rule fires or a message is sent"},
+	{"breakpoints", "", "list every breakpoint"},
+	{"delete", "ID", "remove a breakpoint"},
+	{"c, continue", "[N]", fmt.Sprintf("step until a breakpoint is hit, for at most N steps (%d by default)", defaultContinueSteps)},
+	{"checkpoint", "FILE", "save the runner's state to a file"},
+	{"h, help", "", "print this list"},
+}
+
+// printHelp prints every REPL command.
+func printHelp() {
+	tabw := new(tabwriter.Writer)
+	tabw.Init(os.Stdout, 4, 8, 2, ' ', 0)
+	for _, c := range replCommands {
+		fmt.Fprintf(tabw, "%s\t%s\t%s\n", c[0], c[1], c[2])
+	}
+	tabw.Flush()
+}
@@ -3,7 +3,10 @@ package engine
 import (
 	"encoding/json"
 	"fmt"
-	"strconv"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slices"
 )
 
 // aggregator defines various supported aggregation functions.
@@ -29,79 +32,50 @@ func (a aggregator) Valid() bool {
 	}
 }
 
-// It is (for now) assumed that the strings can be converted to the correct type for the aggregation
-// operation (only numbers for now). While this is not great, until a better type system is implemented,
-// it will suffice.
+// Do folds val into the running aggregate prev. The type checker guarantees that val has a type
+// supported by the aggregator (numbers for sum, ordered types for max and min).
 //
 // Prev being nil means no previous value has been processed.
-func (a aggregator) Do(prevOptional *string, val string) string {
-	noPrev := false
-	prev := ""
-	if prevOptional == nil {
-		noPrev = true
-		prev = "0"
-	} else {
-		prev = *prevOptional
-	}
-
-	pi, pf, floatP, err := stringToNumber(prev)
-	if err != nil {
-		panic(err)
-	}
-
-	vi, vf, floatV, err := stringToNumber(val)
-	if err != nil {
-		panic(err)
-	}
-	float := floatP || floatV
-
+func (a aggregator) Do(prev *value, val value) value {
 	switch a {
 	case aggregatorCount:
-		if float {
-			panic("the count aggregator was passed a float, but it only works with ints")
+		if prev == nil {
+			return intValue(1)
 		}
-
-		return strconv.Itoa(pi + 1)
+		return intValue(prev.i + 1)
 
 	case aggregatorFirst:
-		if noPrev {
+		if prev == nil {
 			return val
 		}
-		return prev
+		return *prev
 
 	case aggregatorMax, aggregatorMin:
-		var next string
-		if !float {
-			nextI := pi
-			if noPrev || a == aggregatorMax && vi > pi || a == aggregatorMin && vi < pi {
-				nextI = vi
-			}
-			next = strconv.Itoa(nextI)
-		} else {
-			nextF := pf
-			if noPrev || a == aggregatorMax && vf > pf || a == aggregatorMin && vf < pf {
-				nextF = vf
-			}
-			next = fmt.Sprintf("%f", nextF)
+		if prev == nil {
+			return val
 		}
-		return next
+		cmp := val.compare(*prev)
+		if a == aggregatorMax && cmp > 0 || a == aggregatorMin && cmp < 0 {
+			return val
+		}
+		return *prev
 
 	case aggregatorSum:
-		var next string
-		if !float {
-			next = strconv.Itoa(pi + vi)
-		} else {
-			next = fmt.Sprintf("%f", pf+vf)
+		if prev == nil {
+			return val
 		}
-		return next
+		if prev.typ == typeInt && val.typ == typeInt {
+			return intValue(prev.i + val.i)
+		}
+		return floatValue(prev.float() + val.float())
 	}
 
-	return ""
+	return value{}
 }
 
 // This function operates on the output of join.
 // This is EXTREMELY hacky/non-clean code, but it works as a proof of concept.
-func aggregate(rl *Rule, data [][]string) [][]string {
+func aggregate(rl *Rule, derivations []*derivation) []*derivation {
 	type aggIndex struct {
 		i   int
 		agg *aggregator
@@ -117,51 +91,79 @@ func aggregate(rl *Rule, data [][]string) [][]string {
 			aggIndices = append(aggIndices, ai)
 		}
 	}
-	nonAggIndices = append(nonAggIndices, len(rl.headVarMapping))
 
 	type aggVar struct {
 		i   int
-		val string
+		val value
 	}
 	type pendingAgg struct {
-		nonAgg []string
-		agg    []*aggVar
+		d   *derivation
+		agg []*aggVar
 	}
 
+	// Join reads facts out of maps, so the derivations are sorted first: groups are then kept in the
+	// order they are first seen and their values are folded in a fixed order, which keeps the output
+	// (and float sums) deterministic.
+	derivations = sortDerivations(derivations)
+
+	var order []string
 	pendingAggData := map[string]*pendingAgg{}
-	for _, d := range data {
-		var nonAgg []string
+	for _, d := range derivations {
+		var key []string
 		for _, i := range nonAggIndices {
-			nonAgg = append(nonAgg, d[i])
+			key = append(key, d.data[i].String())
 		}
+		key = append(key, d.to)
 
-		b, _ := json.Marshal(nonAgg)
+		b, _ := json.Marshal(key)
 		if pa, ok := pendingAggData[string(b)]; !ok {
-			pa := pendingAgg{nonAgg: nonAgg}
+			pa := pendingAgg{d: &derivation{rule: d.rule, from: d.from, to: d.to, support: d.support}}
 			for _, ai := range aggIndices {
-				av := aggVar{i: ai.i, val: ai.agg.Do(nil, d[ai.i])}
+				av := aggVar{i: ai.i, val: ai.agg.Do(nil, d.data[ai.i])}
 				pa.agg = append(pa.agg, &av)
 			}
+			pa.d.data = slices.Clone(d.data)
 			pendingAggData[string(b)] = &pa
+			order = append(order, string(b))
 		} else {
 			for i, ai := range aggIndices {
 				av := pa.agg[i]
-				av.val = ai.agg.Do(&av.val, d[ai.i])
+				av.val = ai.agg.Do(&av.val, d.data[ai.i])
 			}
+			// Every fact which contributed to the group supports the aggregated fact.
+			pa.d.support = append(pa.d.support, d.support...)
 		}
 	}
 
-	var aggData [][]string
-	for _, pa := range pendingAggData {
-		d := make([]string, len(rl.headVarMapping)+1)
-		for i, di := range nonAggIndices {
-			d[di] = pa.nonAgg[i]
-		}
+	aggregated := make([]*derivation, 0, len(order))
+	for _, k := range order {
+		pa := pendingAggData[k]
 		for _, av := range pa.agg {
-			d[av.i] = av.val
+			pa.d.data[av.i] = av.val
+		}
+		aggregated = append(aggregated, pa.d)
+	}
+
+	return aggregated
+}
+
+// sortDerivations returns the derivations sorted by their head tuple, destination, origin and
+// supporting facts. The order is arbitrary but does not depend on map iteration.
+func sortDerivations(derivations []*derivation) []*derivation {
+	keys := make(map[*derivation]string, len(derivations))
+	for _, d := range derivations {
+		var sb strings.Builder
+		sb.WriteString(tupleKey(d.data))
+		fmt.Fprintf(&sb, "\x00%s\x00%s", d.to, d.from)
+		for _, support := range d.support {
+			for _, f := range support {
+				fmt.Fprintf(&sb, "\x00%s@%s,%d", tupleKey(f.data), f.location, f.timestamp)
+			}
 		}
-		aggData = append(aggData, d)
+		keys[d] = sb.String()
 	}
 
-	return aggData
+	sorted := slices.Clone(derivations)
+	sort.SliceStable(sorted, func(i, j int) bool { return keys[sorted[i]] < keys[sorted[j]] })
+	return sorted
 }
@@ -0,0 +1,128 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Fact is a single fact in a relation. Attributes are in their textual form, as in the program.
+// Facts in read-only relations have no location or timestamp.
+type Fact struct {
+	Relation  string
+	Tuple     []string
+	Location  string
+	Timestamp int
+}
+
+func (rel *Relation) exportFact(f *fact) Fact {
+	return Fact{Relation: rel.id, Tuple: valueStrings(f.data), Location: f.location, Timestamp: f.timestamp}
+}
+
+// derivedCallback is a function registered with OnDerive.
+type derivedCallback struct {
+	// Nil for callbacks which observe every relation.
+	rel *Relation
+	fn  func(Fact)
+}
+
+// Relations returns the names of every relation in the program, sorted.
+func (s *State) Relations() []string {
+	names := make([]string, 0, len(s.relations))
+	for name := range s.relations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Locations returns every location known to the runner, sorted.
+func (s *State) Locations() []string {
+	return s.sortedLocations()
+}
+
+// Insert adds a fact to a relation at the given location and timestep, which must not have been
+// evaluated yet. The location and timestep are ignored for read-only relations. It returns whether
+// the fact is new.
+func (r *Runner) Insert(relation string, tuple []string, loc string, time int) (bool, error) {
+	rel, ok := r.relations[relation]
+	if !ok {
+		return false, fmt.Errorf("the relation %q does not exist", relation)
+	}
+	data, err := rel.parseRow(tuple)
+	if err != nil {
+		return false, err
+	}
+
+	if !rel.readOnly {
+		if loc == "" {
+			return false, fmt.Errorf("facts in %q require a location", relation)
+		} else if time < r.clock(loc) {
+			return false, fmt.Errorf("timestep %d has already been evaluated", time)
+		}
+		r.locations[loc] = struct{}{}
+	}
+	return rel.push(data, loc, time), nil
+}
+
+// Query returns the facts in a relation which match the pattern, sorted by timestamp, location and
+// then attributes. The pattern has one entry per attribute, where "_" matches any value (a nil
+// pattern matches every fact). An empty location or a negative timestep matches every location or
+// timestep.
+func (r *Runner) Query(relation string, pattern []string, loc string, time int) ([]Fact, error) {
+	var facts []Fact
+	err := r.EachFact(relation, pattern, loc, time, func(f Fact) bool {
+		facts = append(facts, f)
+		return true
+	})
+	return facts, err
+}
+
+// EachFact calls fn for every fact in the relation matching the pattern (as in Query), in the same
+// order, until fn returns false.
+func (r *Runner) EachFact(relation string, pattern []string, loc string, time int, fn func(Fact) bool) error {
+	rel, ok := r.relations[relation]
+	if !ok {
+		return fmt.Errorf("the relation %q does not exist", relation)
+	}
+
+	bound := make([]*value, rel.numAttrs())
+	if pattern != nil {
+		if len(pattern) != rel.numAttrs() {
+			return fmt.Errorf("%q has %d attributes, but %d were given", relation, rel.numAttrs(), len(pattern))
+		}
+		var err error
+		if bound, err = rel.parsePattern(pattern); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range rel.sortedFacts() {
+		if !rel.readOnly && (loc != "" && f.location != loc || time >= 0 && f.timestamp != time) {
+			continue
+		}
+		if !matchesPattern(f.data, bound) {
+			continue
+		}
+		if !fn(rel.exportFact(f)) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// OnDerive registers a callback which is called with every fact newly derived for the relation (or
+// for any relation, if the name is empty) as soon as it is derived. Facts carried forward by
+// automatic persistence are not reported.
+func (r *Runner) OnDerive(relation string, fn func(Fact)) error {
+	cb := derivedCallback{fn: fn}
+	if relation != "" {
+		rel, ok := r.relations[relation]
+		if !ok {
+			return fmt.Errorf("the relation %q does not exist", relation)
+		}
+		cb.rel = rel
+	}
+
+	r.callbacks = append(r.callbacks, cb)
+	return nil
+}
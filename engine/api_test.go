@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rithvikp/dedalus/ast"
+)
+
+func TestEmbeddingAPI(t *testing.T) {
+	const program = `
+.decl req(id: int)
+.decl peer(node: location) edb
+Got(id,l',t') :- req(id,l,t), peer(l'), choose((id),t')
+`
+
+	p, err := ast.Parse(strings.NewReader(program))
+	if err != nil {
+		t.Errorf("unable to parse the program: %v", err)
+		return
+	}
+	r, err := NewRunner(p)
+	if err != nil {
+		t.Errorf("unable to initialize the runner: %v", err)
+		return
+	}
+	r.SetDerivationLog(nil)
+	r.SetNetworkModel(FixedDelay(1))
+
+	var derived []Fact
+	if err := r.OnDerive("Got", func(f Fact) { derived = append(derived, f) }); err != nil {
+		t.Errorf("unable to register a callback: %v", err)
+		return
+	}
+
+	inserts := []struct {
+		relation string
+		tuple    []string
+		loc      string
+		time     int
+	}{
+		{"peer", []string{"B"}, "", 0},
+		{"req", []string{"1"}, "A", 0},
+		{"req", []string{"2"}, "A", 1},
+	}
+	for _, in := range inserts {
+		if _, err := r.Insert(in.relation, in.tuple, in.loc, in.time); err != nil {
+			t.Errorf("unable to insert %s%v: %v", in.relation, in.tuple, err)
+			return
+		}
+	}
+	for i := 0; i < 3; i++ {
+		r.Step()
+	}
+
+	wantDerived := []Fact{
+		{Relation: "Got", Tuple: []string{"1"}, Location: "B", Timestamp: 1},
+		{Relation: "Got", Tuple: []string{"2"}, Location: "B", Timestamp: 2},
+	}
+	if diff := cmp.Diff(derived, wantDerived); diff != "" {
+		t.Errorf("derived facts diff (-got, +want):\n%s", diff)
+	}
+
+	queries := []struct {
+		msg     string
+		pattern []string
+		loc     string
+		time    int
+		want    []Fact
+	}{
+		{
+			msg:  "everything",
+			time: -1,
+			want: []Fact{
+				{Relation: "Got", Tuple: []string{"1"}, Location: "B", Timestamp: 1},
+				{Relation: "Got", Tuple: []string{"1"}, Location: "B", Timestamp: 2},
+				{Relation: "Got", Tuple: []string{"2"}, Location: "B", Timestamp: 2},
+				{Relation: "Got", Tuple: []string{"1"}, Location: "B", Timestamp: 3},
+				{Relation: "Got", Tuple: []string{"2"}, Location: "B", Timestamp: 3},
+			},
+		},
+		{
+			msg:     "bound attribute",
+			pattern: []string{"2"},
+			time:    -1,
+			want: []Fact{
+				{Relation: "Got", Tuple: []string{"2"}, Location: "B", Timestamp: 2},
+				{Relation: "Got", Tuple: []string{"2"}, Location: "B", Timestamp: 3},
+			},
+		},
+		{
+			msg:     "location and time",
+			pattern: []string{"_"},
+			loc:     "B",
+			time:    1,
+			want: []Fact{
+				{Relation: "Got", Tuple: []string{"1"}, Location: "B", Timestamp: 1},
+			},
+		},
+		{
+			msg:  "no matches",
+			loc:  "A",
+			time: -1,
+		},
+	}
+	for _, q := range queries {
+		got, err := r.Query("Got", q.pattern, q.loc, q.time)
+		if err != nil {
+			t.Errorf("%s: unable to query: %v", q.msg, err)
+			continue
+		}
+		if diff := cmp.Diff(got, q.want); diff != "" {
+			t.Errorf("%s: query diff (-got, +want):\n%s", q.msg, diff)
+		}
+	}
+
+	var first []Fact
+	if err := r.EachFact("Got", nil, "", -1, func(f Fact) bool {
+		first = append(first, f)
+		return false
+	}); err != nil || len(first) != 1 {
+		t.Errorf("expected iteration to stop after the first fact, but got %v (%v)", first, err)
+	}
+
+	if _, err := r.Insert("req", []string{"3"}, "A", 0); err == nil {
+		t.Errorf("expected an error when inserting into an evaluated timestep")
+	}
+	if _, err := r.Insert("req", []string{"three"}, "A", 5); err == nil {
+		t.Errorf("expected an error when inserting a value of the wrong type")
+	}
+	if _, err := r.Query("missing", nil, "", -1); err == nil {
+		t.Errorf("expected an error when querying a relation which does not exist")
+	}
+}
@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// StopReason is the condition which ended a call to RunUntil.
+type StopReason int
+
+const (
+	StopTimestep StopReason = iota
+	StopQuiescent
+	StopNonEmpty
+	// The maximum number of steps was executed before any other condition was met.
+	StopMaxSteps
+)
+
+func (s StopReason) String() string {
+	switch s {
+	case StopTimestep:
+		return "reached the final timestep"
+	case StopQuiescent:
+		return "quiescent"
+	case StopNonEmpty:
+		return "relation became non-empty"
+	default:
+		return "reached the maximum number of steps"
+	}
+}
+
+// RunOptions determines when RunUntil stops. Execution stops as soon as any of the set conditions
+// is met, and at least one must be set.
+type RunOptions struct {
+	// If positive, stop once the runner reaches this timestep.
+	Timestep int
+	// If positive, stop once the facts at every location (ignoring their timestamps) are unchanged
+	// for this many consecutive timesteps and nothing is still in flight, so facts which are only
+	// carried forward by automatic persistence do not count as changes.
+	QuiescentSteps int
+	// If non-empty, stop after the first timestep at which this relation contains a fact.
+	NonEmpty string
+	// If positive, stop after executing this many timesteps.
+	MaxSteps int
+}
+
+// RunResult describes how a call to RunUntil ended.
+type RunResult struct {
+	Reason StopReason
+	// The number of timesteps executed.
+	Steps int
+}
+
+// RunUntil steps the runner until one of the conditions in opts is met.
+func (r *Runner) RunUntil(opts RunOptions) (*RunResult, error) {
+	if opts.Timestep <= 0 && opts.QuiescentSteps <= 0 && opts.NonEmpty == "" && opts.MaxSteps <= 0 {
+		return nil, errors.New("at least one stopping condition is required")
+	}
+	var nonEmpty *Relation
+	if opts.NonEmpty != "" {
+		rel, ok := r.relations[opts.NonEmpty]
+		if !ok {
+			return nil, fmt.Errorf("the relation %q does not exist", opts.NonEmpty)
+		}
+		nonEmpty = rel
+	}
+
+	res := &RunResult{}
+	prev := r.lastSignature()
+	unchanged := 0
+	for {
+		if opts.Timestep > 0 && r.currentTimestamp >= opts.Timestep {
+			res.Reason = StopTimestep
+			return res, nil
+		}
+		if opts.MaxSteps > 0 && res.Steps >= opts.MaxSteps {
+			res.Reason = StopMaxSteps
+			return res, nil
+		}
+
+		time := r.currentTimestamp
+		r.Step()
+		res.Steps++
+		if r.scheduler != nil {
+			time = r.lastStep.timestamp
+		}
+
+		if nonEmpty != nil && r.holdsAt(nonEmpty, time) {
+			res.Reason = StopNonEmpty
+			return res, nil
+		}
+
+		if opts.QuiescentSteps > 0 {
+			sig := r.lastSignature()
+			if sig == prev {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+			prev = sig
+
+			if unchanged >= opts.QuiescentSteps && !r.pending() {
+				res.Reason = StopQuiescent
+				return res, nil
+			}
+		}
+	}
+}
+
+// holdsAt reports whether the relation contains a fact at any location at the given time.
+func (r *Runner) holdsAt(rel *Relation, time int) bool {
+	for _, loc := range r.sortedLocations() {
+		if len(rel.all(loc, time)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// lastSignature summarizes the facts at the last timestep each location executed, ignoring their
+// timestamps so that consecutive timesteps with the same facts have the same signature.
+func (r *Runner) lastSignature() string {
+	var lines []string
+	for _, rel := range r.relations {
+		if rel.readOnly {
+			continue
+		}
+		for _, loc := range r.sortedLocations() {
+			for _, f := range rel.all(loc, r.clock(loc)-1) {
+				lines = append(lines, fmt.Sprintf("%s(%s)@%s", rel.id, strings.Join(valueStrings(f.data), ","), f.location))
+			}
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// pending reports whether anything scheduled could still change the state: facts delivered to a
+// timestep which has yet to execute, derivations held by partitions, or faults which have not
+// finished.
+func (r *Runner) pending() bool {
+	if len(r.held) > 0 {
+		return true
+	}
+	for _, f := range r.faults {
+		if f.CrashAt >= r.clock(f.Location) || f.RestartAt >= r.clock(f.Location) {
+			return true
+		}
+	}
+	for _, rel := range r.relations {
+		if rel.readOnly {
+			continue
+		}
+		for lt := range rel.ltIndex {
+			now := r.clock(lt.location)
+			if lt.timestamp > now || lt.timestamp == now && rel.arrivedAt(lt) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// arrivedAt reports whether any fact at the location and timestep was delivered there rather than
+// carried over from the previous timestep (by persistence or a successor rule), so it may derive
+// something new when the timestep executes: facts sent by async rules or other nodes, and facts
+// given by the program, inserted or restored.
+func (rel *Relation) arrivedAt(lt locTime) bool {
+	for _, f := range rel.tuples[lt] {
+		p := f.prov
+		if p == nil || p.restored || p.receivedFrom != "" || p.rule != nil && p.rule.timeModel == TimeModelAsync {
+			return true
+		}
+	}
+	return false
+}
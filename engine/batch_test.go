@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rithvikp/dedalus/ast"
+)
+
+func TestRunUntil(t *testing.T) {
+	const program = `
+peer("L2").
+start("x",L1,0).
+num("1",L1,0).
+Seen(v,l,t) :- start(v,l,t)
+Got(v,l',t') :- start(v,l,t), peer(l'), choose((v),t')
+never(v,l,t) :- num(v,l,t), v > 5
+`
+
+	tests := []struct {
+		msg string
+		// The program to run, if not the one above.
+		program string
+		// The fixed network delay, if not 2.
+		delay int
+		opts  RunOptions
+		want  *RunResult
+		err   bool
+	}{
+		{
+			msg:  "timestep",
+			opts: RunOptions{Timestep: 3},
+			want: &RunResult{Reason: StopTimestep, Steps: 3},
+		},
+		{
+			// Got arrives at timestep 2, after which nothing changes.
+			msg:  "quiescence",
+			opts: RunOptions{QuiescentSteps: 2},
+			want: &RunResult{Reason: StopQuiescent, Steps: 5},
+		},
+		{
+			// out arrives at timestep 3, and must be pending once timestep 2 (which has the same
+			// facts as the timestep before) executes.
+			msg: "quiescence with a message due at the next timestep",
+			program: `
+src("x",A,0).
+out(v,l,t') :- src(v,l,t), choose((v),t')
+Done(v,l,t) :- out(v,l,t)
+`,
+			delay: 3,
+			opts:  RunOptions{QuiescentSteps: 1},
+			want:  &RunResult{Reason: StopQuiescent, Steps: 6},
+		},
+		{
+			msg:  "non-empty relation",
+			opts: RunOptions{NonEmpty: "Got"},
+			want: &RunResult{Reason: StopNonEmpty, Steps: 3},
+		},
+		{
+			msg:  "maximum steps",
+			opts: RunOptions{NonEmpty: "never", MaxSteps: 4},
+			want: &RunResult{Reason: StopMaxSteps, Steps: 4},
+		},
+		{
+			msg:  "earliest condition",
+			opts: RunOptions{Timestep: 10, NonEmpty: "Got"},
+			want: &RunResult{Reason: StopNonEmpty, Steps: 3},
+		},
+		{
+			msg: "no conditions",
+			err: true,
+		},
+		{
+			msg:  "unknown relation",
+			opts: RunOptions{NonEmpty: "missing"},
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			source := program
+			if tt.program != "" {
+				source = tt.program
+			}
+			p, err := ast.Parse(strings.NewReader(source))
+			if err != nil {
+				t.Errorf("unable to parse the program: %v", err)
+				return
+			}
+
+			r, err := NewRunner(p)
+			if err != nil {
+				t.Errorf("unable to initialize the runner: %v", err)
+				return
+			}
+			r.SetDerivationLog(nil)
+			delay := 2
+			if tt.delay > 0 {
+				delay = tt.delay
+			}
+			r.SetNetworkModel(FixedDelay(delay))
+
+			res, err := r.RunUntil(tt.opts)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected an error, but got the result %+v", res)
+				}
+				return
+			} else if err != nil {
+				t.Errorf("unable to run the program: %v", err)
+				return
+			}
+
+			if diff := cmp.Diff(res, tt.want); diff != "" {
+				t.Errorf("result diff (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// BreakpointKind is the condition which triggers a breakpoint.
+type BreakpointKind int
+
+const (
+	// BreakOnFact triggers when Relation receives a new fact matching Pattern (a watchpoint),
+	// optionally only at Location.
+	BreakOnFact BreakpointKind = iota
+	// BreakOnRule triggers when the rule with the ID Rule, or any rule on source line Line, derives
+	// a new fact.
+	BreakOnRule
+	// BreakOnMessage triggers when a fact derived by an async rule arrives at Location (or at any
+	// location, if it is empty).
+	BreakOnMessage
+)
+
+// Breakpoint stops Continue at the end of a timestep during which its condition was met.
+type Breakpoint struct {
+	// Assigned by AddBreakpoint.
+	ID   int
+	Kind BreakpointKind
+
+	Relation string
+	// One entry per attribute, where "_" matches any value. A nil pattern matches every fact.
+	Pattern []string
+
+	Rule string
+	Line int
+
+	Location string
+}
+
+func (b Breakpoint) String() string {
+	switch b.Kind {
+	case BreakOnFact:
+		s := b.Relation
+		if b.Pattern != nil {
+			s += "(" + strings.Join(b.Pattern, ", ") + ")"
+		}
+		if b.Location != "" {
+			s += " @ " + b.Location
+		}
+		return s
+	case BreakOnRule:
+		if b.Rule != "" {
+			return "rule " + b.Rule
+		}
+		return fmt.Sprintf("rules on line %d", b.Line)
+	default:
+		if b.Location != "" {
+			return "messages to " + b.Location
+		}
+		return "any message"
+	}
+}
+
+// breakpoint is a Breakpoint resolved against the program.
+type breakpoint struct {
+	Breakpoint
+	rel   *Relation
+	bound []*value
+}
+
+// BreakpointHit is a fact whose derivation (or arrival, for messages) triggered a breakpoint.
+type BreakpointHit struct {
+	Breakpoint Breakpoint
+	Fact       Fact
+	Derivation *Derivation
+}
+
+func (h BreakpointHit) String() string {
+	return fmt.Sprintf("Breakpoint %d (%s) hit by:\n%s", h.Breakpoint.ID, h.Breakpoint, h.Derivation)
+}
+
+// AddBreakpoint sets a breakpoint and returns its ID.
+func (r *Runner) AddBreakpoint(b Breakpoint) (int, error) {
+	bp := &breakpoint{Breakpoint: b}
+	switch b.Kind {
+	case BreakOnFact:
+		rel, ok := r.relations[b.Relation]
+		if !ok {
+			return 0, fmt.Errorf("the relation %q does not exist", b.Relation)
+		}
+		bp.rel = rel
+		if b.Pattern != nil {
+			if len(b.Pattern) != rel.numAttrs() {
+				return 0, fmt.Errorf("%q has %d attributes, but %d were given", b.Relation, rel.numAttrs(), len(b.Pattern))
+			}
+			bound, err := rel.parsePattern(b.Pattern)
+			if err != nil {
+				return 0, err
+			}
+			bp.bound = bound
+		}
+	case BreakOnRule:
+		found := false
+		for _, rl := range r.rules {
+			found = found || bp.matchesRule(rl)
+		}
+		if !found && b.Rule != "" {
+			return 0, fmt.Errorf("the rule %q does not exist", b.Rule)
+		} else if !found {
+			return 0, fmt.Errorf("there is no rule on line %d", b.Line)
+		}
+	case BreakOnMessage:
+	default:
+		return 0, fmt.Errorf("unknown breakpoint kind %d", b.Kind)
+	}
+
+	r.nextBreakpoint++
+	bp.ID = r.nextBreakpoint
+	r.breakpoints = append(r.breakpoints, bp)
+	return bp.ID, nil
+}
+
+// RemoveBreakpoint removes the breakpoint with the given ID.
+func (r *Runner) RemoveBreakpoint(id int) error {
+	for i, bp := range r.breakpoints {
+		if bp.ID == id {
+			r.breakpoints = append(r.breakpoints[:i], r.breakpoints[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("breakpoint %d does not exist", id)
+}
+
+// Breakpoints returns every breakpoint, in the order they were added.
+func (r *Runner) Breakpoints() []Breakpoint {
+	bs := make([]Breakpoint, len(r.breakpoints))
+	for i, bp := range r.breakpoints {
+		bs[i] = bp.Breakpoint
+	}
+	return bs
+}
+
+// Continue steps the runner until a breakpoint is hit, returning every hit during that timestep. If
+// maxSteps is positive, it stops after that many timesteps even if no breakpoint was hit, in which
+// case it returns no hits.
+func (r *Runner) Continue(maxSteps int) ([]BreakpointHit, error) {
+	if len(r.breakpoints) == 0 {
+		return nil, errors.New("no breakpoints are set")
+	}
+
+	for steps := 0; maxSteps <= 0 || steps < maxSteps; steps++ {
+		r.Step()
+		if len(r.hits) > 0 {
+			return r.hits, nil
+		}
+	}
+	return nil, nil
+}
+
+func (bp *breakpoint) matchesRule(rl *Rule) bool {
+	return bp.Rule != "" && rl.id == bp.Rule || bp.Rule == "" && rl.pos.Line == bp.Line
+}
+
+// checkDerived records a hit for every fact or rule breakpoint triggered by a newly derived fact.
+func (r *Runner) checkDerived(rl *Rule, f *fact) {
+	for _, bp := range r.breakpoints {
+		switch bp.Kind {
+		case BreakOnFact:
+			if bp.rel != rl.head || bp.Location != "" && bp.Location != f.location || !matchesPattern(f.data, bp.bound) {
+				continue
+			}
+		case BreakOnRule:
+			if !bp.matchesRule(rl) {
+				continue
+			}
+		default:
+			continue
+		}
+		r.hit(bp, rl.head, f)
+	}
+}
+
+// checkArrivals records a hit for every message breakpoint triggered by a fact derived by an async
+// rule which arrives at the current timestep.
+func (r *Runner) checkArrivals() {
+	for _, bp := range r.breakpoints {
+		if bp.Kind != BreakOnMessage {
+			continue
+		}
+
+		locs := r.sortedLocations()
+		if bp.Location != "" {
+			locs = []string{bp.Location}
+		}
+		if r.local != "" {
+			// Only the location which just executed a timestep has moved to a new one.
+			if bp.Location != "" && bp.Location != r.local {
+				continue
+			}
+			locs = []string{r.local}
+		}
+		for _, name := range r.Relations() {
+			rel := r.relations[name]
+			if rel.readOnly {
+				continue
+			}
+			for _, loc := range locs {
+				facts := rel.all(loc, r.currentTimestamp)
+				sort.Slice(facts, func(i, j int) bool {
+					return strings.Join(valueStrings(facts[i].data), "\x00") < strings.Join(valueStrings(facts[j].data), "\x00")
+				})
+				for _, f := range facts {
+					if f.prov != nil && f.prov.rule != nil && f.prov.rule.timeModel == TimeModelAsync {
+						r.hit(bp, rel, f)
+					}
+				}
+			}
+		}
+	}
+}
+
+func (r *Runner) hit(bp *breakpoint, rel *Relation, f *fact) {
+	r.hits = append(r.hits, BreakpointHit{
+		Breakpoint: bp.Breakpoint,
+		Fact:       rel.exportFact(f),
+		Derivation: explain(rel, f, map[*fact]*Derivation{}),
+	})
+}
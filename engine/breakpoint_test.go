@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rithvikp/dedalus/ast"
+)
+
+func TestBreakpoints(t *testing.T) {
+	// A pings B every timestep, with a delay of 2, and B remembers every ping.
+	const program = `
+peer("B").
+Src("x",A,0).
+ping(v,t,l',t') :- Src(v,l,t), peer(l'), choose((v,t),t')
+Got(v,s,l,t) :- ping(v,s,l,t)
+`
+
+	tests := []struct {
+		msg        string
+		breakpoint Breakpoint
+		// The timestep at which Continue stops, and the facts which triggered the breakpoint.
+		timestep int
+		facts    []Fact
+		wantErr  bool
+	}{
+		{
+			msg:        "fact",
+			breakpoint: Breakpoint{Kind: BreakOnFact, Relation: "Got", Pattern: []string{"_", "1"}},
+			timestep:   4,
+			facts:      []Fact{{Relation: "Got", Tuple: []string{"x", "1"}, Location: "B", Timestamp: 3}},
+		},
+		{
+			msg:        "fact at another location",
+			breakpoint: Breakpoint{Kind: BreakOnFact, Relation: "Got", Location: "A"},
+			timestep:   10,
+		},
+		{
+			msg:        "rule",
+			breakpoint: Breakpoint{Kind: BreakOnRule, Rule: "1"},
+			timestep:   3,
+			facts:      []Fact{{Relation: "Got", Tuple: []string{"x", "0"}, Location: "B", Timestamp: 2}},
+		},
+		{
+			msg:        "line",
+			breakpoint: Breakpoint{Kind: BreakOnRule, Line: 4},
+			timestep:   1,
+			facts:      []Fact{{Relation: "ping", Tuple: []string{"x", "0"}, Location: "B", Timestamp: 2}},
+		},
+		{
+			msg:        "message",
+			breakpoint: Breakpoint{Kind: BreakOnMessage, Location: "B"},
+			timestep:   2,
+			facts:      []Fact{{Relation: "ping", Tuple: []string{"x", "0"}, Location: "B", Timestamp: 2}},
+		},
+		{
+			msg:        "unknown relation",
+			breakpoint: Breakpoint{Kind: BreakOnFact, Relation: "pong"},
+			wantErr:    true,
+		},
+		{
+			msg:        "invalid pattern",
+			breakpoint: Breakpoint{Kind: BreakOnFact, Relation: "Got", Pattern: []string{"_"}},
+			wantErr:    true,
+		},
+		{
+			msg:        "unknown line",
+			breakpoint: Breakpoint{Kind: BreakOnRule, Line: 2},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			p, err := ast.Parse(strings.NewReader(program))
+			if err != nil {
+				t.Fatalf("unable to parse the program: %v", err)
+			}
+			r, err := NewRunner(p)
+			if err != nil {
+				t.Fatalf("unable to initialize the runner: %v", err)
+			}
+			r.SetDerivationLog(nil)
+			r.SetNetworkModel(FixedDelay(2))
+
+			id, err := r.AddBreakpoint(tt.breakpoint)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error adding the breakpoint")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unable to add the breakpoint: %v", err)
+			}
+
+			hits, err := r.Continue(10)
+			if err != nil {
+				t.Fatalf("unable to continue: %v", err)
+			}
+			if r.CurrentTimestamp() != tt.timestep {
+				t.Errorf("stopped at timestep %d, but expected %d", r.CurrentTimestamp(), tt.timestep)
+			}
+			var facts []Fact
+			for _, h := range hits {
+				if h.Breakpoint.ID != id {
+					t.Errorf("hit breakpoint %d, but expected %d", h.Breakpoint.ID, id)
+				}
+				if h.Derivation == nil || h.Derivation.Relation != h.Fact.Relation {
+					t.Errorf("the hit by %v has no derivation", h.Fact)
+				}
+				facts = append(facts, h.Fact)
+			}
+			if diff := cmp.Diff(tt.facts, facts); diff != "" {
+				t.Errorf("unexpected facts hit (-want +got):\n%s", diff)
+			}
+
+			if err := r.RemoveBreakpoint(id); err != nil {
+				t.Errorf("unable to remove the breakpoint: %v", err)
+			}
+			if _, err := r.Continue(1); err == nil {
+				t.Errorf("expected an error continuing without breakpoints")
+			}
+		})
+	}
+}
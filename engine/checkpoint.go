@@ -0,0 +1,286 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/rithvikp/dedalus/ast"
+)
+
+// checkpointVersion is incremented whenever the checkpoint format changes incompatibly.
+const checkpointVersion = 1
+
+// checkpoint is the serialized state of a runner, written as JSON.
+type checkpoint struct {
+	Version     int    `json:"version"`
+	ProgramHash string `json:"program_hash"`
+	Timestep    int    `json:"timestep"`
+	Seed        int64  `json:"seed"`
+
+	Locations []string `json:"locations"`
+	// The timestep each location executes next, if they have their own clocks.
+	Clocks    map[string]int              `json:"clocks"`
+	Relations map[string][]checkpointFact `json:"relations"`
+
+	Faults     []Fault     `json:"faults,omitempty"`
+	Partitions []Partition `json:"partitions,omitempty"`
+	// The persisted facts of crashed locations, keyed by location and then relation.
+	Recovered map[string]map[string][]checkpointFact `json:"recovered,omitempty"`
+	Held      []checkpointHeld                       `json:"held,omitempty"`
+}
+
+type checkpointFact struct {
+	Tuple    []string `json:"tuple"`
+	Location string   `json:"loc,omitempty"`
+	Time     int      `json:"time,omitempty"`
+}
+
+// checkpointHeld is a derivation held by a partition, identified by its index in Partitions.
+type checkpointHeld struct {
+	Rule      string   `json:"rule"`
+	Tuple     []string `json:"tuple"`
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	Partition int      `json:"partition"`
+}
+
+func checkpointFacts(facts []*fact) []checkpointFact {
+	cfs := make([]checkpointFact, len(facts))
+	for i, f := range facts {
+		cfs[i] = checkpointFact{Tuple: valueStrings(f.data), Location: f.location, Time: f.timestamp}
+	}
+	sort.Slice(cfs, func(i, j int) bool {
+		if cfs[i].Time != cfs[j].Time {
+			return cfs[i].Time < cfs[j].Time
+		} else if cfs[i].Location != cfs[j].Location {
+			return cfs[i].Location < cfs[j].Location
+		}
+		return fmt.Sprint(cfs[i].Tuple) < fmt.Sprint(cfs[j].Tuple)
+	})
+	return cfs
+}
+
+// Checkpoint writes the runner's state to w: the facts of every relation, the known locations, the
+// current timestep and seed, and any scheduled faults and partitions along with the state they
+// hold. The network model is not included. Restore resumes from a checkpoint.
+func (r *Runner) Checkpoint(w io.Writer) error {
+	c := checkpoint{
+		Version:     checkpointVersion,
+		ProgramHash: r.programHash,
+		Timestep:    r.currentTimestamp,
+		Seed:        r.seed,
+		Locations:   r.sortedLocations(),
+		Relations:   map[string][]checkpointFact{},
+		Faults:      r.faults,
+		Clocks:      r.Clocks(),
+	}
+
+	for name, rel := range r.relations {
+		c.Relations[name] = checkpointFacts(rel.allAcrossSpaceTime())
+	}
+
+	partitions := map[*Partition]int{}
+	for i, p := range r.partitions {
+		c.Partitions = append(c.Partitions, *p)
+		partitions[p] = i
+	}
+	for _, h := range r.held {
+		c.Held = append(c.Held, checkpointHeld{
+			Rule:      h.d.rule.id,
+			Tuple:     valueStrings(h.d.data),
+			From:      h.d.from,
+			To:        h.d.to,
+			Partition: partitions[h.partition],
+		})
+	}
+
+	if len(r.recovered) > 0 {
+		c.Recovered = map[string]map[string][]checkpointFact{}
+		for loc, saved := range r.recovered {
+			c.Recovered[loc] = map[string][]checkpointFact{}
+			for rel, facts := range saved {
+				c.Recovered[loc][rel.id] = checkpointFacts(facts)
+			}
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c)
+}
+
+// Restore replaces the runner's state with a checkpoint written by Checkpoint, which must have been
+// taken with the same program. The facts it restores have no provenance, so their derivations are
+// reported as restored rather than explained.
+func (r *Runner) Restore(rd io.Reader) error {
+	var c checkpoint
+	if err := json.NewDecoder(rd).Decode(&c); err != nil {
+		return fmt.Errorf("unable to read the checkpoint: %v", err)
+	}
+	if c.Version != checkpointVersion {
+		return fmt.Errorf("unsupported checkpoint version %d (expected %d)", c.Version, checkpointVersion)
+	} else if c.ProgramHash != r.programHash {
+		return errors.New("the checkpoint was taken with a different program")
+	} else if c.Clocks == nil && r.scheduler != nil {
+		return errors.New("the checkpoint was taken without a scheduler, so locations do not have their own clocks")
+	} else if c.Clocks != nil && r.scheduler == nil {
+		return errors.New("the checkpoint was taken with a scheduler, which must be set again before it is restored")
+	}
+
+	// Parse everything before modifying the runner, so it is unchanged if the checkpoint is invalid.
+	facts := map[*Relation][]*fact{}
+	for name, cfs := range c.Relations {
+		rel, ok := r.relations[name]
+		if !ok {
+			return fmt.Errorf("the relation %q does not exist", name)
+		}
+		fs, err := r.restoredFacts(rel, cfs)
+		if err != nil {
+			return err
+		}
+		facts[rel] = fs
+	}
+
+	var partitions []*Partition
+	for i := range c.Partitions {
+		partitions = append(partitions, &c.Partitions[i])
+	}
+
+	rules := map[string]*Rule{}
+	for _, rl := range r.rules {
+		rules[rl.id] = rl
+	}
+	var held []heldDerivation
+	for _, h := range c.Held {
+		rl, ok := rules[h.Rule]
+		if !ok {
+			return fmt.Errorf("the rule %q does not exist", h.Rule)
+		} else if h.Partition < 0 || h.Partition >= len(partitions) {
+			return fmt.Errorf("a derivation is held by partition %d, which does not exist", h.Partition)
+		}
+		data, err := rl.head.parseRow(h.Tuple)
+		if err != nil {
+			return err
+		}
+		held = append(held, heldDerivation{
+			d:         &derivation{rule: rl, data: data, from: h.From, to: h.To},
+			partition: partitions[h.Partition],
+		})
+	}
+
+	var recovered map[string]map[*Relation][]*fact
+	if len(c.Recovered) > 0 {
+		recovered = map[string]map[*Relation][]*fact{}
+		for loc, saved := range c.Recovered {
+			recovered[loc] = map[*Relation][]*fact{}
+			for name, cfs := range saved {
+				rel, ok := r.relations[name]
+				if !ok {
+					return fmt.Errorf("the relation %q does not exist", name)
+				}
+				fs, err := r.restoredFacts(rel, cfs)
+				if err != nil {
+					return err
+				}
+				recovered[loc][rel] = fs
+			}
+		}
+	}
+
+	for _, rel := range r.relations {
+		rel.clear()
+		for _, f := range facts[rel] {
+			rel.index(f)
+		}
+	}
+	r.locations = map[string]struct{}{}
+	for _, loc := range c.Locations {
+		r.locations[loc] = struct{}{}
+	}
+	r.currentTimestamp = c.Timestep
+	if r.scheduler != nil {
+		r.clocks = c.Clocks
+		r.syncClocks()
+	}
+	r.seed = c.Seed
+	r.faults = c.Faults
+	r.partitions = partitions
+	r.held = held
+	r.recovered = recovered
+	r.executed = r.executed || c.Timestep > 0
+	r.history = nil
+	return nil
+}
+
+// restoredFacts parses facts from a checkpoint, rejecting duplicates.
+func (r *Runner) restoredFacts(rel *Relation, cfs []checkpointFact) ([]*fact, error) {
+	scratch := newRelation(rel.id, rel.readOnly, false, rel.numAttrs())
+	var facts []*fact
+	for _, cf := range cfs {
+		data, err := rel.parseRow(cf.Tuple)
+		if err != nil {
+			return nil, err
+		}
+		f := scratch.insert(data, cf.Location, cf.Time)
+		if f == nil {
+			return nil, fmt.Errorf("%s%v appears more than once at %s at timestep %d", rel.id, cf.Tuple, cf.Location, cf.Time)
+		}
+		f.prov = &provenance{restored: true}
+		facts = append(facts, f)
+	}
+	return facts, nil
+}
+
+// hashProgram returns a hash of the program's statements, ignoring comments and formatting. The
+// paths of .input and .output directives are also ignored, since the same program may refer to
+// them differently (for example, relative to another working directory).
+func hashProgram(p *ast.Program) string {
+	h := sha256.New()
+	for _, st := range p.Statements {
+		if st.Comment == nil {
+			hashNode(h, reflect.ValueOf(st))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+var (
+	positionType = reflect.TypeOf(lexer.Position{})
+	// Types whose Path field is not hashed.
+	directiveTypes = map[reflect.Type]bool{reflect.TypeOf(ast.Input{}): true, reflect.TypeOf(ast.Output{}): true}
+)
+
+func hashNode(w io.Writer, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			fmt.Fprint(w, "nil;")
+			return
+		}
+		hashNode(w, v.Elem())
+	case reflect.Struct:
+		fmt.Fprintf(w, "%s{", v.Type().Name())
+		for i := 0; i < v.NumField(); i++ {
+			if v.Field(i).Type() == positionType || directiveTypes[v.Type()] && v.Type().Field(i).Name == "Path" {
+				continue
+			}
+			hashNode(w, v.Field(i))
+		}
+		fmt.Fprint(w, "}")
+	case reflect.Slice:
+		fmt.Fprintf(w, "[%d:", v.Len())
+		for i := 0; i < v.Len(); i++ {
+			hashNode(w, v.Index(i))
+		}
+		fmt.Fprint(w, "]")
+	default:
+		fmt.Fprintf(w, "%q;", fmt.Sprint(v.Interface()))
+	}
+}
@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rithvikp/dedalus/ast"
+)
+
+func TestCheckpoint(t *testing.T) {
+	// A pings B every timestep, and B remembers every ping.
+	const program = `
+peer("B").
+Src("x",A,0).
+ping(v,t,l',t') :- Src(v,l,t), peer(l'), choose((v,t),t')
+Got(v,s,l,t) :- ping(v,s,l,t)
+`
+
+	tests := []struct {
+		msg        string
+		fault      *Fault
+		partition  *Partition
+		checkpoint int
+	}{
+		{msg: "no faults", checkpoint: 4},
+		{msg: "before a crash", fault: &Fault{Location: "B", CrashAt: 5, RestartAt: 8}, checkpoint: 3},
+		{msg: "during a crash", fault: &Fault{Location: "B", CrashAt: 4, RestartAt: 8}, checkpoint: 6},
+		{msg: "during a partition", partition: &Partition{Start: 2, End: 7, Groups: [][]string{{"A"}, {"B"}}, Mode: PartitionHold}, checkpoint: 4},
+	}
+
+	newTestRunner := func(t *testing.T, program string) *Runner {
+		t.Helper()
+		p, err := ast.Parse(strings.NewReader(program))
+		if err != nil {
+			t.Fatalf("unable to parse the program: %v", err)
+		}
+		r, err := NewRunner(p)
+		if err != nil {
+			t.Fatalf("unable to initialize the runner: %v", err)
+		}
+		r.SetDerivationLog(nil)
+		r.SetNetworkModel(UniformDelay{Min: 1, Max: 3})
+		return r
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			orig := newTestRunner(t, program)
+			orig.SetSeed(7)
+			if tt.fault != nil {
+				if err := orig.AddFault(*tt.fault); err != nil {
+					t.Fatalf("unable to add the fault: %v", err)
+				}
+			}
+			if tt.partition != nil {
+				if err := orig.AddPartition(*tt.partition); err != nil {
+					t.Fatalf("unable to add the partition: %v", err)
+				}
+			}
+
+			for i := 0; i < tt.checkpoint; i++ {
+				orig.Step()
+			}
+			var buf bytes.Buffer
+			if err := orig.Checkpoint(&buf); err != nil {
+				t.Fatalf("unable to checkpoint the runner: %v", err)
+			}
+
+			restored := newTestRunner(t, program)
+			if err := restored.Restore(&buf); err != nil {
+				t.Fatalf("unable to restore the runner: %v", err)
+			}
+			if restored.CurrentTimestamp() != tt.checkpoint {
+				t.Errorf("restored at timestep %d, but the checkpoint was taken at %d", restored.CurrentTimestamp(), tt.checkpoint)
+			}
+			// Faults and partitions given again (as when the same flags are passed with --restore)
+			// are already scheduled.
+			if tt.fault != nil {
+				if err := restored.AddFault(*tt.fault); err != nil {
+					t.Errorf("unable to add the restored fault again: %v", err)
+				}
+			}
+			if tt.partition != nil {
+				if err := restored.AddPartition(*tt.partition); err != nil {
+					t.Errorf("unable to add the restored partition again: %v", err)
+				}
+			}
+
+			// Both runners must continue identically.
+			for i := tt.checkpoint; i < 10; i++ {
+				orig.Step()
+				restored.Step()
+			}
+			var want, got bytes.Buffer
+			if err := orig.WriteRelations(&want, FormatJSONL); err != nil {
+				t.Fatalf("unable to write the original relations: %v", err)
+			}
+			if err := restored.WriteRelations(&got, FormatJSONL); err != nil {
+				t.Fatalf("unable to write the restored relations: %v", err)
+			}
+			if diff := cmp.Diff(want.String(), got.String()); diff != "" {
+				t.Errorf("the restored runner diverged (-original +restored):\n%s", diff)
+			}
+
+			d, err := restored.Why("Src", []string{"x"}, "A", 1)
+			if err != nil {
+				t.Fatalf("unable to explain a restored fact: %v", err)
+			}
+			if !d.Restored {
+				t.Errorf("expected a restored derivation, but got:\n%s", d)
+			}
+		})
+	}
+
+	t.Run("different program", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := newTestRunner(t, program).Checkpoint(&buf); err != nil {
+			t.Fatalf("unable to checkpoint the runner: %v", err)
+		}
+		// Comments and formatting do not change the program.
+		if err := newTestRunner(t, "# Reformatted\n"+strings.ReplaceAll(program, ",", ", ")).Restore(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Errorf("unable to restore into a reformatted program: %v", err)
+		}
+		if err := newTestRunner(t, program+"Done(v,l,t) :- Got(v,s,l,t)\n").Restore(bytes.NewReader(buf.Bytes())); err == nil {
+			t.Errorf("expected an error restoring into a different program")
+		}
+
+		// Directive paths depend on where the program is run from.
+		hash := func(source string) string {
+			p, err := ast.Parse(strings.NewReader(source))
+			if err != nil {
+				t.Fatalf("unable to parse the program: %v", err)
+			}
+			return hashProgram(p)
+		}
+		if hash(program+`.input Src("a/src.csv")`) != hash(program+`.input Src("../b/src.csv")`) {
+			t.Errorf("expected programs differing only in directive paths to have the same hash")
+		}
+		if hash(program+`.output Got("got.csv")`) == hash(program+`.output ping("got.csv")`) {
+			t.Errorf("expected programs with different directives to have different hashes")
+		}
+	})
+}
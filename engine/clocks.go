@@ -0,0 +1,188 @@
+package engine
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+)
+
+// Scheduler picks the location which executes the next timestep when every location has its own
+// clock. It is given every location (sorted by name) along with the timestep each will execute
+// next, and must return one of them.
+type Scheduler interface {
+	Next(locs []string, clocks []int, rng *rand.Rand) string
+}
+
+// RoundRobinScheduler steps the location with the earliest clock (breaking ties by name), so clocks
+// never drift more than one timestep apart.
+type RoundRobinScheduler struct{}
+
+func (RoundRobinScheduler) Next(locs []string, clocks []int, rng *rand.Rand) string {
+	next := 0
+	for i := range locs {
+		if clocks[i] < clocks[next] {
+			next = i
+		}
+	}
+	return locs[next]
+}
+
+// RandomScheduler steps a location chosen uniformly at random. If MaxSkew is positive, only
+// locations less than MaxSkew timesteps ahead of the slowest location are chosen.
+type RandomScheduler struct {
+	MaxSkew int
+}
+
+func (s RandomScheduler) Next(locs []string, clocks []int, rng *rand.Rand) string {
+	min := clocks[0]
+	for _, c := range clocks {
+		if c < min {
+			min = c
+		}
+	}
+
+	var candidates []string
+	for i, loc := range locs {
+		if s.MaxSkew <= 0 || clocks[i] < min+s.MaxSkew {
+			candidates = append(candidates, loc)
+		}
+	}
+	return candidates[rng.Intn(len(candidates))]
+}
+
+// SetScheduler gives every location its own clock, so that each call to Step executes a single
+// timestep at the location chosen by the scheduler. Facts derived for another location are
+// delivered relative to its clock: async facts arrive after their network delay, facts derived by
+// successor rules at the receiver's next timestep, and any others at the timestep it executes next.
+// CurrentTimestamp is the earliest timestep any location has yet to execute. It must be called
+// before the runner is executed.
+func (r *Runner) SetScheduler(s Scheduler) error {
+	if r.executed {
+		return errors.New("the runner has already been executed")
+	} else if r.local != "" {
+		return errors.New("nodes keep their own clocks, so they cannot have a scheduler")
+	}
+
+	r.scheduler = s
+	r.clocks = map[string]int{}
+	r.ship = func(d *derivation) {
+		switch d.rule.timeModel {
+		case TimeModelAsync:
+			for _, delay := range r.delays(d) {
+				r.deliverAt(d, r.clock(d.to)+delay)
+			}
+		case TimeModelSuccessor:
+			r.deliverAt(d, r.clock(d.to)+1)
+		default:
+			r.deliverAt(d, r.clock(d.to))
+		}
+	}
+	r.syncClocks()
+	return nil
+}
+
+// Clocks returns the timestep each location will execute next. It is nil unless the runner has a
+// scheduler.
+func (r *Runner) Clocks() map[string]int {
+	if r.scheduler == nil {
+		return nil
+	}
+	r.syncClocks()
+	clocks := map[string]int{}
+	for loc, c := range r.clocks {
+		clocks[loc] = c
+	}
+	return clocks
+}
+
+// StepLocation executes the next timestep of a single location, regardless of the scheduler. The
+// runner must have a scheduler.
+func (r *Runner) StepLocation(loc string) error {
+	if r.scheduler == nil {
+		return errors.New("locations only have their own clocks once a scheduler is set")
+	} else if _, ok := r.locations[loc]; !ok {
+		return fmt.Errorf("the location %q does not exist", loc)
+	}
+	r.stepLocation(loc)
+	return nil
+}
+
+// stepScheduled executes the next timestep of the location chosen by the scheduler.
+func (r *Runner) stepScheduled() {
+	r.syncClocks()
+	locs := r.sortedLocations()
+	if len(locs) == 0 {
+		r.currentTimestamp++
+		return
+	}
+
+	clocks := make([]int, len(locs))
+	for i, loc := range locs {
+		clocks[i] = r.clocks[loc]
+	}
+	r.stepLocation(r.scheduler.Next(locs, clocks, r.scheduleRand(locs, clocks)))
+}
+
+func (r *Runner) stepLocation(loc string) {
+	r.syncClocks()
+	earliest := r.currentTimestamp
+	r.hits = nil
+	r.local = loc
+	r.currentTimestamp = r.clocks[loc]
+	r.lastStep = locTime{loc, r.currentTimestamp}
+
+	for _, msg := range r.evaluate() {
+		r.send(msg)
+	}
+	r.advance()
+	r.clocks[loc] = r.currentTimestamp
+	r.checkArrivals()
+
+	// Locations which first appeared during the timestep join at the earliest clock.
+	r.local = ""
+	r.currentTimestamp = earliest
+	r.syncClocks()
+}
+
+// syncClocks starts the clocks of new locations at the current timestep, and then moves the current
+// timestep to the earliest clock.
+func (r *Runner) syncClocks() {
+	if len(r.locations) == 0 {
+		return
+	}
+	min := -1
+	for loc := range r.locations {
+		c, ok := r.clocks[loc]
+		if !ok {
+			c = r.currentTimestamp
+			r.clocks[loc] = c
+		}
+		if min < 0 || c < min {
+			min = c
+		}
+	}
+	r.currentTimestamp = min
+}
+
+// clock returns the timestep the location will execute next.
+func (r *Runner) clock(loc string) int {
+	if c, ok := r.clocks[loc]; ok {
+		return c
+	}
+	return r.currentTimestamp
+}
+
+// scheduleRand returns a source of randomness determined by the runner's seed and the clocks, so
+// that runs with the same seed follow the same schedule.
+func (r *Runner) scheduleRand(locs []string, clocks []int) *rand.Rand {
+	h := sha1.New()
+	h.Write([]byte(strconv.FormatInt(r.seed, 10)))
+	for i, loc := range locs {
+		h.Write([]byte{0})
+		h.Write([]byte(loc + "@" + strconv.Itoa(clocks[i])))
+	}
+	return rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(h.Sum(nil)[:8]))))
+}
@@ -0,0 +1,194 @@
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rithvikp/dedalus/ast"
+)
+
+func TestClocks(t *testing.T) {
+	// A pings B with its clock every timestep, and B remembers every ping.
+	const program = `
+peer("B").
+Src("x",A,0).
+ping(v,t,l',t') :- Src(v,l,t), peer(l'), choose((v,t),t')
+Got(v,s,l,t) :- ping(v,s,l,t)
+`
+
+	newTestRunner := func(t *testing.T, s Scheduler) *Runner {
+		t.Helper()
+		p, err := ast.Parse(strings.NewReader(program))
+		if err != nil {
+			t.Fatalf("unable to parse the program: %v", err)
+		}
+		r, err := NewRunner(p)
+		if err != nil {
+			t.Fatalf("unable to initialize the runner: %v", err)
+		}
+		r.SetDerivationLog(nil)
+		r.SetNetworkModel(FixedDelay(1))
+		if s != nil {
+			if err := r.SetScheduler(s); err != nil {
+				t.Fatalf("unable to set the scheduler: %v", err)
+			}
+		}
+		return r
+	}
+	relations := func(t *testing.T, r *Runner) string {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := r.WriteRelations(&buf, FormatJSONL); err != nil {
+			t.Fatalf("unable to write the relations: %v", err)
+		}
+		return buf.String()
+	}
+
+	t.Run("async facts arrive in the receiver's time", func(t *testing.T) {
+		r := newTestRunner(t, RoundRobinScheduler{})
+		for i := 0; i < 3; i++ {
+			if err := r.StepLocation("A"); err != nil {
+				t.Fatalf("unable to step A: %v", err)
+			}
+		}
+		for i := 0; i < 2; i++ {
+			if err := r.StepLocation("B"); err != nil {
+				t.Fatalf("unable to step B: %v", err)
+			}
+		}
+
+		// B had not executed a timestep when any of the pings were sent, so they all arrive at its
+		// first timestep after its clock.
+		want := []Fact{
+			{Relation: "Got", Tuple: []string{"x", "0"}, Location: "B", Timestamp: 1},
+			{Relation: "Got", Tuple: []string{"x", "1"}, Location: "B", Timestamp: 1},
+			{Relation: "Got", Tuple: []string{"x", "2"}, Location: "B", Timestamp: 1},
+		}
+		got, err := r.Query("Got", nil, "B", 1)
+		if err != nil {
+			t.Fatalf("unable to query Got: %v", err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("unexpected facts in Got (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff(map[string]int{"A": 3, "B": 2}, r.Clocks()); diff != "" {
+			t.Errorf("unexpected clocks (-want +got):\n%s", diff)
+		}
+		if r.CurrentTimestamp() != 2 {
+			t.Errorf("expected the current timestep to be the earliest clock (2), but it is %d", r.CurrentTimestamp())
+		}
+
+		if _, err := r.Insert("Src", []string{"y"}, "A", 2); err == nil {
+			t.Errorf("expected inserting a fact before A's clock to fail")
+		}
+		if _, err := r.Insert("Src", []string{"y"}, "B", 2); err != nil {
+			t.Errorf("unable to insert a fact at B's clock: %v", err)
+		}
+	})
+
+	t.Run("round robin matches lock-step", func(t *testing.T) {
+		lockStep := newTestRunner(t, nil)
+		if _, err := lockStep.RunUntil(RunOptions{Timestep: 5}); err != nil {
+			t.Fatalf("unable to run in lock-step: %v", err)
+		}
+		r := newTestRunner(t, RoundRobinScheduler{})
+		res, err := r.RunUntil(RunOptions{Timestep: 5})
+		if err != nil {
+			t.Fatalf("unable to run with clocks: %v", err)
+		}
+
+		if res.Steps != 10 {
+			t.Errorf("expected each location to execute 5 timesteps, but %d were executed in total", res.Steps)
+		}
+		if diff := cmp.Diff(relations(t, lockStep), relations(t, r)); diff != "" {
+			t.Errorf("unexpected relations (-lock-step +clocks):\n%s", diff)
+		}
+	})
+
+	t.Run("random schedules are bounded and reproducible", func(t *testing.T) {
+		run := func() (string, []string) {
+			r := newTestRunner(t, RandomScheduler{MaxSkew: 3})
+			r.SetSeed(7)
+			var schedule []string
+			for i := 0; i < 40; i++ {
+				r.Step()
+				schedule = append(schedule, r.lastStep.location)
+				min, max := r.CurrentTimestamp(), 0
+				for _, c := range r.Clocks() {
+					if c > max {
+						max = c
+					}
+				}
+				if max-min > 3 {
+					t.Fatalf("the clocks drifted %d timesteps apart after %d steps: %v", max-min, i+1, r.Clocks())
+				}
+			}
+			return relations(t, r), schedule
+		}
+
+		rels, schedule := run()
+		rels2, schedule2 := run()
+		if diff := cmp.Diff(schedule, schedule2); diff != "" {
+			t.Errorf("expected the same schedule with the same seed (-first +second):\n%s", diff)
+		}
+		if diff := cmp.Diff(rels, rels2); diff != "" {
+			t.Errorf("expected the same relations with the same seed (-first +second):\n%s", diff)
+		}
+	})
+
+	t.Run("checkpoint", func(t *testing.T) {
+		r := newTestRunner(t, RandomScheduler{})
+		r.SetSeed(3)
+		for i := 0; i < 12; i++ {
+			r.Step()
+		}
+		var buf bytes.Buffer
+		if err := r.Checkpoint(&buf); err != nil {
+			t.Fatalf("unable to save the checkpoint: %v", err)
+		}
+
+		if err := newTestRunner(t, nil).Restore(bytes.NewReader(buf.Bytes())); err == nil {
+			t.Errorf("expected restoring per-location clocks without a scheduler to fail")
+		}
+
+		restored := newTestRunner(t, RandomScheduler{})
+		if err := restored.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatalf("unable to restore the checkpoint: %v", err)
+		}
+		if diff := cmp.Diff(r.Clocks(), restored.Clocks()); diff != "" {
+			t.Errorf("unexpected clocks after restoring (-want +got):\n%s", diff)
+		}
+		for i := 0; i < 12; i++ {
+			r.Step()
+			restored.Step()
+		}
+		if diff := cmp.Diff(relations(t, r), relations(t, restored)); diff != "" {
+			t.Errorf("unexpected relations after resuming (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		r := newTestRunner(t, nil)
+		if err := r.StepLocation("A"); err == nil {
+			t.Errorf("expected stepping a location without a scheduler to fail")
+		}
+		r.Step()
+		if err := r.SetScheduler(RoundRobinScheduler{}); err == nil {
+			t.Errorf("expected setting a scheduler after execution to fail")
+		}
+
+		r = newTestRunner(t, RoundRobinScheduler{})
+		r.Step()
+		if err := r.Rewind(0); err == nil {
+			t.Errorf("expected rewinding with per-location clocks to fail")
+		}
+		if _, err := r.Explore(ExploreOptions{MaxDelay: 1, MaxSteps: 1}); err == nil {
+			t.Errorf("expected exploring with per-location clocks to fail")
+		}
+		if err := r.StepLocation("C"); err == nil {
+			t.Errorf("expected stepping an unknown location to fail")
+		}
+	})
+}
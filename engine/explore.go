@@ -0,0 +1,230 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ExploreOptions bounds the schedules considered by Explore.
+type ExploreOptions struct {
+	// Async derivations are delivered between 1 and MaxDelay timesteps after they are sent.
+	MaxDelay int
+	// The number of timesteps to execute along every schedule.
+	MaxSteps int
+	// If positive, exploration stops after this many distinct states have been visited.
+	MaxStates int
+	// Exploration stops after this many schedules (the delays chosen for the async derivations of a
+	// single timestep) have been tried, including those which reach states already visited, since
+	// each timestep can have up to MaxDelay^n schedules for n derivations. DefaultMaxSchedules is
+	// used if it is not positive.
+	MaxSchedules int
+
+	// Relations which must never contain a fact. These are typically derived by rules which detect
+	// the violation of a safety property.
+	Invariants []string
+}
+
+// DefaultMaxSchedules is the number of schedules Explore tries unless ExploreOptions.MaxSchedules
+// is set.
+const DefaultMaxSchedules = 1000000
+
+// Delivery is the delay chosen for a single async derivation.
+type Delivery struct {
+	Rule     string
+	Relation string
+	Tuple    []string
+	From     string
+	To       string
+	Delay    int
+}
+
+func (d Delivery) String() string {
+	return fmt.Sprintf("%s(%s) %s -> %s after %d (rule %s)", d.Relation, strings.Join(d.Tuple, ","), d.From, d.To, d.Delay, d.Rule)
+}
+
+// TraceStep describes the deliveries chosen for the async derivations of a single timestep.
+type TraceStep struct {
+	Timestamp  int
+	Deliveries []Delivery
+}
+
+// Violation is a state in which an invariant relation contains facts, along with the schedule that
+// led to it.
+type Violation struct {
+	Relation  string
+	Timestamp int
+	// Each fact is rendered as its attributes followed by its location.
+	Facts [][]string
+	Trace []TraceStep
+}
+
+type ExploreResult struct {
+	// Nil if no reachable state violates an invariant.
+	Violation *Violation
+	// The number of distinct states visited, and of schedules tried.
+	States    int
+	Schedules int
+	// Set if exploration stopped early because MaxStates or MaxSchedules was reached.
+	Truncated bool
+}
+
+// Explore systematically enumerates the delivery delays of every async derivation, starting from
+// the runner's current state, and searches for a state which violates one of the invariants. States
+// are explored in breadth-first order, so any reported violation has a minimal-length trace.
+// The runner is restored to its original state once exploration is complete.
+func (r *Runner) Explore(opts ExploreOptions) (*ExploreResult, error) {
+	if r.scheduler != nil {
+		return nil, errors.New("schedules cannot be explored once locations have their own clocks")
+	} else if opts.MaxDelay < 1 {
+		return nil, fmt.Errorf("the maximum delay must be at least 1, but is %d", opts.MaxDelay)
+	}
+	if opts.MaxSchedules <= 0 {
+		opts.MaxSchedules = DefaultMaxSchedules
+	}
+	var invariants []*Relation
+	for _, name := range opts.Invariants {
+		rel, ok := r.relations[name]
+		if !ok {
+			return nil, fmt.Errorf("the invariant relation %q does not exist", name)
+		}
+		invariants = append(invariants, rel)
+	}
+
+	type node struct {
+		snap  *snapshot
+		trace []TraceStep
+	}
+
+	initial := r.snapshot()
+	log, sinks, callbacks, breakpoints := r.derivationLog, r.sinks, r.callbacks, r.breakpoints
+	defer func() {
+		r.restore(initial)
+		r.derivationLog, r.sinks, r.callbacks, r.breakpoints = log, sinks, callbacks, breakpoints
+	}()
+	r.derivationLog, r.sinks, r.callbacks, r.breakpoints = nil, nil, nil, nil
+
+	res := &ExploreResult{States: 1}
+	visited := map[string]bool{r.signature(): true}
+	queue := []node{{snap: initial}}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+
+		r.restore(n.snap)
+		evaluatedMsgs := uniqueDerivations(r.evaluate())
+		if v := r.violation(invariants); v != nil {
+			v.Trace = n.trace
+			res.Violation = v
+			return res, nil
+		}
+
+		if len(n.trace) >= opts.MaxSteps {
+			continue
+		}
+
+		// Derivations which cross a partition are dropped or held regardless of their delay.
+		var msgs []*derivation
+		for _, msg := range evaluatedMsgs {
+			if !r.intercept(msg) {
+				msgs = append(msgs, msg)
+			}
+		}
+		evaluated := r.snapshot()
+		delays := make([]int, len(msgs))
+		for i := range delays {
+			delays[i] = 1
+		}
+		for {
+			if res.Schedules >= opts.MaxSchedules {
+				res.Truncated = true
+				return res, nil
+			}
+			res.Schedules++
+
+			r.restore(evaluated)
+			step := TraceStep{Timestamp: r.currentTimestamp}
+			for i, msg := range msgs {
+				r.deliver(msg, r.currentTimestamp+delays[i])
+				step.Deliveries = append(step.Deliveries, Delivery{
+					Rule:     msg.rule.id,
+					Relation: msg.rule.head.id,
+					Tuple:    valueStrings(msg.data),
+					From:     msg.from,
+					To:       msg.to,
+					Delay:    delays[i],
+				})
+			}
+			r.advance()
+
+			if sig := r.signature(); !visited[sig] {
+				if opts.MaxStates > 0 && res.States >= opts.MaxStates {
+					res.Truncated = true
+					return res, nil
+				}
+				visited[sig] = true
+				res.States++
+
+				trace := append(append([]TraceStep{}, n.trace...), step)
+				queue = append(queue, node{snap: r.snapshot(), trace: trace})
+			}
+
+			if !nextDelays(delays, opts.MaxDelay) {
+				break
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// nextDelays advances delays to the next combination (like an odometer), returning false once every
+// combination has been produced.
+func nextDelays(delays []int, maxDelay int) bool {
+	for i := range delays {
+		if delays[i] < maxDelay {
+			delays[i]++
+			return true
+		}
+		delays[i] = 1
+	}
+	return false
+}
+
+// uniqueDerivations removes derivations of the same fact for the same location, which would
+// otherwise multiply the number of schedules without producing new states.
+func uniqueDerivations(ds []*derivation) []*derivation {
+	seen := map[string]bool{}
+	var unique []*derivation
+	for _, d := range ds {
+		k := fmt.Sprintf("%s(%s)@%s", d.rule.head.id, strings.Join(valueStrings(d.data), ","), d.to)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		unique = append(unique, d)
+	}
+	return unique
+}
+
+// violation returns the first invariant relation with facts at the current timestep, if any.
+func (r *Runner) violation(invariants []*Relation) *Violation {
+	for _, rel := range invariants {
+		var facts [][]string
+		for _, loc := range r.sortedLocations() {
+			for _, f := range rel.all(loc, r.currentTimestamp) {
+				facts = append(facts, append(valueStrings(f.data), f.location))
+			}
+		}
+		if len(facts) == 0 {
+			continue
+		}
+
+		sort.Slice(facts, func(i, j int) bool {
+			return strings.Join(facts[i], ",") < strings.Join(facts[j], ",")
+		})
+		return &Violation{Relation: rel.id, Timestamp: r.currentTimestamp, Facts: facts}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rithvikp/dedalus/ast"
+)
+
+func TestExplore(t *testing.T) {
+	// B must never receive "2" without having received "1", even though A sends them in order.
+	const source = `
+peer("B").
+out("1",A,0).
+out("2",A,1).
+recv(v,l',t') :- out(v,l,t), peer(l'), choose((v),t')
+Got1(l,t) :- recv(v,l,t), v=1
+bad(l,t) :- recv(v,l,t), v=2, not Got1(l,t)`
+
+	tests := []struct {
+		msg       string
+		opts      ExploreOptions
+		violation bool
+		trace     []int // The delays chosen in each step of the trace
+		truncated bool
+	}{
+		{
+			msg:  "no reordering",
+			opts: ExploreOptions{MaxDelay: 1, MaxSteps: 6, Invariants: []string{"bad"}},
+		},
+		{
+			msg:  "insufficient reordering",
+			opts: ExploreOptions{MaxDelay: 2, MaxSteps: 6, Invariants: []string{"bad"}},
+		},
+		{
+			msg:       "reordering",
+			opts:      ExploreOptions{MaxDelay: 3, MaxSteps: 6, Invariants: []string{"bad"}},
+			violation: true,
+			trace:     []int{3, 1},
+		},
+		{
+			msg:  "too few steps",
+			opts: ExploreOptions{MaxDelay: 3, MaxSteps: 1, Invariants: []string{"bad"}},
+		},
+		{
+			msg:       "schedule budget",
+			opts:      ExploreOptions{MaxDelay: 3, MaxSteps: 6, MaxSchedules: 2, Invariants: []string{"bad"}},
+			truncated: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			p, err := ast.Parse(strings.NewReader(source))
+			if err != nil {
+				t.Errorf("unable to parse the program: %v", err)
+				return
+			}
+
+			r, err := NewRunner(p)
+			if err != nil {
+				t.Errorf("unable to initialize the runner: %v", err)
+				return
+			}
+			r.SetDerivationLog(nil)
+
+			res, err := r.Explore(tt.opts)
+			if err != nil {
+				t.Errorf("unable to explore: %v", err)
+				return
+			}
+
+			if res.Truncated != tt.truncated {
+				t.Errorf("expected truncated to be %t, but it is %t after %d schedules", tt.truncated, res.Truncated, res.Schedules)
+			}
+			if !tt.violation {
+				if res.Violation != nil {
+					t.Errorf("unexpected violation: %+v", res.Violation)
+				}
+				return
+			} else if res.Violation == nil {
+				t.Errorf("expected a violation after exploring %d states", res.States)
+				return
+			}
+
+			var delays []int
+			for _, step := range res.Violation.Trace {
+				for _, d := range step.Deliveries {
+					delays = append(delays, d.Delay)
+				}
+			}
+			if diff := cmp.Diff(delays, tt.trace); diff != "" {
+				t.Errorf("trace delay diff (-got, +want):\n%s", diff)
+			}
+			if r.currentTimestamp != 0 {
+				t.Errorf("the runner was not restored after exploring: it is at timestep %d", r.currentTimestamp)
+			}
+		})
+	}
+}
+
+func TestExploreRestoresState(t *testing.T) {
+	// A sends B a fact every timestep, which is held while they are partitioned.
+	const source = `
+peer("B").
+Src("x",A,0).
+got(v,l',t') :- Src(v,l,t), peer(l'), succ(t,t')`
+
+	p, err := ast.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Fatalf("unable to parse the program: %v", err)
+	}
+	r, err := NewRunner(p)
+	if err != nil {
+		t.Fatalf("unable to initialize the runner: %v", err)
+	}
+	r.SetDerivationLog(nil)
+	if err := r.AddPartition(Partition{Start: 0, End: 10, Groups: [][]string{{"A"}, {"B"}}, Mode: PartitionHold}); err != nil {
+		t.Fatalf("unable to add the partition: %v", err)
+	}
+	r.Step()
+	held := len(r.held)
+
+	if _, err := r.Explore(ExploreOptions{MaxDelay: 2, MaxSteps: 4}); err != nil {
+		t.Fatalf("unable to explore: %v", err)
+	}
+	if len(r.held) != held {
+		t.Errorf("expected %d held derivations after exploring, but there are %d", held, len(r.held))
+	}
+}
@@ -1,12 +1,11 @@
 package engine
 
-import (
-	"fmt"
-	"strconv"
-)
+import "math"
 
 type expression interface {
-	eval(value func(v *Variable) string) string
+	// eval returns the value of the expression for the given bindings, or false if it is undefined
+	// (such as when dividing by zero).
+	eval(valueOf func(v *Variable) value) (value, bool)
 }
 
 type binOp struct {
@@ -26,128 +25,103 @@ type condition struct {
 	e1 expression
 	e2 expression
 	op string
+
+	// Every variable the condition reads.
+	vars []*Variable
 }
 
-// stringToNumber converts the given string to an integer (if possible) and a float. If the returned
-// boolean is true, only the float representation is valid.
-func stringToNumber(s string) (int, float64, bool, error) {
-	float := false
-	i, err := strconv.Atoi(s)
-	if err != nil {
-		float = true
-	}
+func newCondition(e1, e2 expression, op string) condition {
+	return condition{e1: e1, e2: e2, op: op, vars: append(expressionVars(e1), expressionVars(e2)...)}
+}
 
-	f, err := strconv.ParseFloat(s, 64)
-	if err != nil {
-		return 0, 0, false, err
+// expressionVars returns every variable the expression reads.
+func expressionVars(e expression) []*Variable {
+	switch e := e.(type) {
+	case *Variable:
+		return []*Variable{e}
+	case *binOp:
+		return append(expressionVars(e.e1), expressionVars(e.e2)...)
 	}
-
-	return i, f, float, nil
+	return nil
 }
 
-func (f *fact) equals(other *fact) bool {
-	if len(f.data) != len(other.data) {
+// eval reports whether the condition holds. Conditions on undefined expressions never hold.
+func (c condition) eval(valueOf func(v *Variable) value) bool {
+	val1, ok1 := c.e1.eval(valueOf)
+	val2, ok2 := c.e2.eval(valueOf)
+	if !ok1 || !ok2 {
 		return false
-	} else if f.location != other.location {
-		return false
-	} else if f.timestamp != other.timestamp {
-		return false
-	}
-
-	for i := range f.data {
-		if f.data[i] != other.data[i] {
-			return false
-		}
 	}
-	return true
-}
-
-func (c condition) eval(value func(v *Variable) string) bool {
-	val1 := c.e1.eval(value)
-	val2 := c.e2.eval(value)
 
 	switch c.op {
 	case "=":
-		return val1 == val2
+		return val1.equal(val2)
 	case "!=":
-		return val1 != val2
-	}
-
-	v1i, v1f, float1, err := stringToNumber(val1)
-	if err != nil {
-		panic(err)
-	}
-	v2i, v2f, float2, err := stringToNumber(val2)
-	if err != nil {
-		panic(err)
-	}
-	float := float1 || float2
-
-	switch c.op {
+		return !val1.equal(val2)
 	case ">":
-		if !float {
-			return v1i > v2i
-		}
-		return v1f > v2f
+		return val1.compare(val2) > 0
 	case ">=":
-		if !float {
-			return v1i >= v2i
-		}
-		return v1f >= v2f
+		return val1.compare(val2) >= 0
 	case "<":
-		if !float {
-			return v1i < v2i
-		}
-		return v1f < v2f
+		return val1.compare(val2) < 0
 	case "<=":
-		if !float {
-			return v1i <= v2i
-		}
-		return v1f <= v2f
+		return val1.compare(val2) <= 0
 	}
 
 	return false
 }
 
-func (v *Variable) eval(value func(v *Variable) string) string {
-	return value(v)
+func (v *Variable) eval(valueOf func(v *Variable) value) (value, bool) {
+	return valueOf(v), true
 }
 
-func (n number) eval(value func(v *Variable) string) string {
-	return strconv.Itoa(int(n))
+func (n number) eval(_ func(v *Variable) value) (value, bool) {
+	return intValue(int(n)), true
 }
 
-func (bo *binOp) eval(value func(v *Variable) string) string {
-	e1 := bo.e1.eval(value)
-	e2 := bo.e2.eval(value)
-
-	v1i, v1f, float1, err := stringToNumber(e1)
-	if err != nil {
-		panic(err)
+func (bo *binOp) eval(valueOf func(v *Variable) value) (value, bool) {
+	v1, ok := bo.e1.eval(valueOf)
+	if !ok {
+		return value{}, false
 	}
-	v2i, v2f, float2, err := stringToNumber(e2)
-	if err != nil {
-		panic(err)
+	v2, ok := bo.e2.eval(valueOf)
+	if !ok {
+		return value{}, false
+	}
+	if bo.op == "/" && v2.float() == 0 {
+		return value{}, false
 	}
-	float := float1 || float2
 
+	if v1.typ == typeInt && v2.typ == typeInt {
+		switch bo.op {
+		case "+":
+			return intValue(v1.i + v2.i), true
+		case "-":
+			return intValue(v1.i - v2.i), true
+		case "*":
+			return intValue(v1.i * v2.i), true
+		case "/":
+			return intValue(v1.i / v2.i), true
+		}
+	}
+
+	f1, f2 := v1.float(), v2.float()
+	var f float64
 	switch bo.op {
 	case "+":
-		if !float {
-			return strconv.Itoa(v1i + v2i)
-		}
-		return fmt.Sprintf("%f", v1f+v2f)
+		f = f1 + f2
 	case "-":
-		if !float {
-			return strconv.Itoa(v1i - v2i)
-		}
-		return fmt.Sprintf("%f", v1f-v2f)
+		f = f1 - f2
 	case "*":
-		if !float {
-			return strconv.Itoa(v1i - v2i)
-		}
-		return fmt.Sprintf("%f", v1f-v2f)
+		f = f1 * f2
+	case "/":
+		f = f1 / f2
+	default:
+		return value{}, false
 	}
-
-	return ""
+	// NaN is not equal to itself, so it cannot be stored in a relation.
+	if math.IsNaN(f) {
+		return value{}, false
+	}
+	return floatValue(f), true
 }
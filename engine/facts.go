@@ -0,0 +1,261 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// factTable is the contents of a file of facts, before it is bound to a relation.
+//
+// CSV and TSV files start with a header row. JSON Lines files contain an object per fact, keyed by
+// column name. Columns named loc and time hold the location and timestamp of each fact (time
+// defaults to 0 if only loc is given); files without them hold facts for read-only relations. The
+// other columns are the relation's attributes, matched by name (as declared, or A0, A1, ...) or, in
+// CSV and TSV files, by position. JSON Lines objects with a relation key naming a different
+// relation are skipped, so files written by WriteRelations can be read back.
+type factTable struct {
+	path    string
+	columns []string
+	rows    [][]string
+	// The line of the file on which each row appears.
+	lines []int
+	// Whether the order of the columns is meaningful.
+	ordered bool
+}
+
+// fileFact is a single fact read from a file.
+type fileFact struct {
+	row  []string
+	loc  string
+	time int
+	line int
+}
+
+func readFactFile(path, relation string) (*factTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch filepath.Ext(path) {
+	case ".csv":
+		return readDelimitedFacts(f, path, ',')
+	case ".tsv":
+		return readDelimitedFacts(f, path, '\t')
+	case ".jsonl":
+		return readJSONLFacts(f, path, relation)
+	}
+	return nil, fmt.Errorf("%s: unknown file type (expected .csv, .tsv or .jsonl)", path)
+}
+
+func readDelimitedFacts(r io.Reader, path string, delim rune) (*factTable, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = delim
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%s: the header row is missing", path)
+	}
+
+	t := &factTable{path: path, columns: records[0], rows: records[1:], ordered: true}
+	for i := range t.rows {
+		t.lines = append(t.lines, i+2)
+	}
+	return t, nil
+}
+
+func readJSONLFacts(r io.Reader, path, relation string) (*factTable, error) {
+	t := &factTable{path: path}
+	var objects []map[string]string
+	columns := map[string]bool{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		dec.UseNumber()
+		var obj map[string]any
+		if err := dec.Decode(&obj); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if rel, ok := obj["relation"]; ok {
+			if rel != relation {
+				continue
+			}
+			delete(obj, "relation")
+		}
+
+		row := map[string]string{}
+		for k, v := range obj {
+			switch v := v.(type) {
+			case string:
+				row[k] = v
+			case json.Number:
+				row[k] = v.String()
+			case bool:
+				row[k] = strconv.FormatBool(v)
+			default:
+				return nil, fmt.Errorf("%s:%d: %q must be a string, number or bool", path, line, k)
+			}
+			columns[k] = true
+		}
+		objects = append(objects, row)
+		t.lines = append(t.lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	for c := range columns {
+		t.columns = append(t.columns, c)
+	}
+	sort.Strings(t.columns)
+	for i, obj := range objects {
+		row := make([]string, len(t.columns))
+		for j, c := range t.columns {
+			v, ok := obj[c]
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: %q is missing", path, t.lines[i], c)
+			}
+			row[j] = v
+		}
+		t.rows = append(t.rows, row)
+	}
+	return t, nil
+}
+
+func (t *factTable) column(name string) int {
+	for i, c := range t.columns {
+		if c == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// attrColumns returns the indexes of the columns which hold attributes.
+func (t *factTable) attrColumns() []int {
+	var cols []int
+	for i, c := range t.columns {
+		if c != "loc" && c != "time" {
+			cols = append(cols, i)
+		}
+	}
+	return cols
+}
+
+// shape returns the number of attributes in the file and whether its facts are located.
+func (t *factTable) shape() (int, bool) {
+	return len(t.attrColumns()), t.column("loc") >= 0
+}
+
+// facts binds the rows of the file to the attributes of the relation.
+func (t *factTable) facts(rel *Relation) ([]fileFact, error) {
+	numAttrs, located := t.shape()
+	if numAttrs != rel.numAttrs() {
+		return nil, fmt.Errorf("%s: %q has %d attributes, but the file has %d", t.path, rel.id, rel.numAttrs(), numAttrs)
+	}
+	if located == rel.readOnly {
+		if located {
+			return nil, fmt.Errorf("%s: %q is read-only, so the file cannot have a loc column", t.path, rel.id)
+		}
+		return nil, fmt.Errorf("%s: %q is located, so the file requires a loc column", t.path, rel.id)
+	}
+	locCol, timeCol := t.column("loc"), t.column("time")
+	if timeCol >= 0 && locCol < 0 {
+		return nil, fmt.Errorf("%s: a time column requires a loc column", t.path)
+	}
+
+	// Match attributes by name if every one is named, and by position otherwise.
+	attrCols := make([]int, rel.numAttrs())
+	byName := true
+	for i := range attrCols {
+		if attrCols[i] = t.column(rel.attrName(i)); attrCols[i] < 0 {
+			byName = false
+		}
+	}
+	if !byName {
+		if !t.ordered {
+			return nil, fmt.Errorf("%s: the columns of %q must be named %v", t.path, rel.id, rel.columns())
+		}
+		attrCols = t.attrColumns()
+	}
+
+	var facts []fileFact
+	for i, row := range t.rows {
+		if len(row) != len(t.columns) {
+			return nil, fmt.Errorf("%s:%d: expected %d columns, but found %d", t.path, t.lines[i], len(t.columns), len(row))
+		}
+		f := fileFact{row: make([]string, len(attrCols)), line: t.lines[i]}
+		for j, c := range attrCols {
+			f.row[j] = row[c]
+		}
+		if locCol >= 0 {
+			f.loc = row[locCol]
+		}
+		if timeCol >= 0 {
+			time, err := strconv.Atoi(row[timeCol])
+			if err != nil || time < 0 {
+				return nil, fmt.Errorf("%s:%d: %q is not a valid timestamp", t.path, t.lines[i], row[timeCol])
+			}
+			f.time = time
+		}
+		facts = append(facts, f)
+	}
+	return facts, nil
+}
+
+// pushFileFacts adds facts read from a file to the relation, whose types must already be known.
+// Every row is parsed before any is added, so the relation is unchanged if any row is invalid.
+func (s *State) pushFileFacts(rel *Relation, path string, facts []fileFact) error {
+	rows := make([][]value, len(facts))
+	for i, f := range facts {
+		row, err := rel.parseRow(f.row)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, f.line, err)
+		}
+		rows[i] = row
+	}
+
+	for i, f := range facts {
+		rel.push(rows[i], f.loc, f.time)
+		if !rel.readOnly {
+			s.locations[f.loc] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// LoadFacts adds the facts in a CSV, TSV or JSON Lines file to a relation (see factTable for the
+// structure of these files).
+func (s *State) LoadFacts(relation, path string) error {
+	rel, ok := s.relations[relation]
+	if !ok {
+		return fmt.Errorf("the relation %q does not exist", relation)
+	}
+
+	t, err := readFactFile(path, relation)
+	if err != nil {
+		return err
+	}
+	facts, err := t.facts(rel)
+	if err != nil {
+		return err
+	}
+	return s.pushFileFacts(rel, path, facts)
+}
@@ -0,0 +1,184 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/rithvikp/dedalus/ast"
+)
+
+func TestInputFiles(t *testing.T) {
+	tests := []struct {
+		msg string
+		// The program may refer to the files by name with %s, which is replaced by their directory.
+		program string
+		files   map[string]string
+		// Relations loaded with LoadFacts after the runner is created.
+		load     map[string]string
+		relation string
+		facts    []*testFact
+		err      bool
+	}{
+		{
+			msg: "csv matched by position",
+			program: `
+.input edge("%s/edges.csv")
+path(a,b,l,t) :- edge(a,b,l,t)
+path(a,c,l,t) :- edge(a,b,l,t), path(b,c,l,t)`,
+			files: map[string]string{
+				"edges.csv": "src,dst,loc,time\n1,2,L1,0\n2,3,L1,0\n",
+			},
+			relation: "path",
+			facts: []*testFact{
+				{[]string{"1", "2"}, "L1", 0}, {[]string{"1", "3"}, "L1", 0}, {[]string{"2", "3"}, "L1", 0},
+			},
+		},
+		{
+			msg: "read-only tsv without times",
+			program: `
+.input weight("%s/weights.tsv")
+.input item("%s/items.csv")
+heavy(i,l,t) :- item(i,l,t), weight(i,w), w > 5`,
+			files: map[string]string{
+				"weights.tsv": "A0\tA1\na\t3\nb\t7.5\n",
+				"items.csv":   "A0,loc\na,L1\nb,L1\n",
+			},
+			relation: "heavy",
+			facts: []*testFact{
+				{[]string{"b"}, "L1", 0},
+			},
+		},
+		{
+			msg: "jsonl matched by name",
+			program: `
+.decl score(points: int, player: string)
+.input score("%s/scores.jsonl")`,
+			files: map[string]string{
+				"scores.jsonl": `{"relation":"score","player":"a","points":10,"loc":"L1","time":2}
+{"relation":"other","A0":"x","loc":"L1","time":0}
+
+{"player":"b","points":2,"loc":"L2","time":0}
+`,
+			},
+			relation: "score",
+			facts: []*testFact{
+				{[]string{"2", "b"}, "L2", 0}, {[]string{"10", "a"}, "L1", 2},
+			},
+		},
+		{
+			msg: "loaded after creation",
+			program: `
+.decl edge(src: string, dst: string)`,
+			files: map[string]string{
+				"edges.csv": "src,dst,loc,time\n1,2,L1,0\n",
+			},
+			load:     map[string]string{"edge": "edges.csv"},
+			relation: "edge",
+			facts: []*testFact{
+				{[]string{"1", "2"}, "L1", 0},
+			},
+		},
+		{
+			msg: "missing loc column",
+			program: `
+.decl edge(src: string, dst: string)
+.input edge("%s/edges.csv")`,
+			files: map[string]string{
+				"edges.csv": "src,dst\n1,2\n",
+			},
+			err: true,
+		},
+		{
+			msg: "invalid values",
+			program: `
+.decl count(n: int)
+.input count("%s/counts.csv")`,
+			files: map[string]string{
+				"counts.csv": "n,loc\nmany,L1\n",
+			},
+			err: true,
+		},
+		{
+			msg: "unknown file type",
+			program: `
+.input edge("%s/edges.txt")`,
+			files: map[string]string{
+				"edges.txt": "1,2\n",
+			},
+			err: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, contents := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644); err != nil {
+					t.Errorf("unable to write %s: %v", name, err)
+					return
+				}
+			}
+			program := strings.ReplaceAll(tt.program, "%s", dir)
+
+			p, err := ast.Parse(strings.NewReader(program))
+			if err != nil {
+				t.Errorf("unable to parse the program: %v", err)
+				return
+			}
+
+			r, err := NewRunner(p)
+			if err == nil {
+				for rel, name := range tt.load {
+					if err = r.LoadFacts(rel, filepath.Join(dir, name)); err != nil {
+						break
+					}
+				}
+			}
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			} else if err != nil {
+				t.Errorf("unable to load the facts: %v", err)
+				return
+			}
+			r.SetDerivationLog(nil)
+			r.evaluate()
+
+			got := toTestFacts(r.relations[tt.relation].allAcrossSpaceTime())
+			if diff := cmp.Diff(got, tt.facts, cmp.AllowUnexported(testFact{}), cmpopts.SortSlices(lessFacts)); diff != "" {
+				t.Errorf("fact diff for relation %s (-got, +want):\n%s", tt.relation, diff)
+			}
+		})
+	}
+}
+
+func TestLoadFactsInvalidRow(t *testing.T) {
+	p, err := ast.Parse(strings.NewReader(`
+.decl count(n: int)
+out(n,l,t) :- count(n,l,t)`))
+	if err != nil {
+		t.Fatalf("unable to parse the program: %v", err)
+	}
+	r, err := NewRunner(p)
+	if err != nil {
+		t.Fatalf("unable to initialize the runner: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "counts.csv")
+	if err := os.WriteFile(path, []byte("n,loc\n1,L1\n2,L1\nmany,L1\n"), 0o644); err != nil {
+		t.Fatalf("unable to write the file: %v", err)
+	}
+	if err := r.LoadFacts("count", path); err == nil {
+		t.Fatalf("expected an error loading an invalid row")
+	}
+	if facts := r.relations["count"].allAcrossSpaceTime(); len(facts) != 0 {
+		t.Errorf("expected no facts to be loaded from a file with an invalid row, but found %v", toTestFacts(facts))
+	}
+}
@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"fmt"
+
+	"golang.org/x/exp/slices"
+)
+
+// Fault crashes a location at a timestep, optionally restarting it later.
+//
+// While a location is down, none of its rules are evaluated and every fact delivered to it is
+// dropped. When it restarts, only its automatically persisted (PascalCased) relations are
+// recovered, with the contents they had when it crashed, unless the crash causes amnesia.
+type Fault struct {
+	Location string
+	CrashAt  int
+	// If positive, the location restarts at this timestep.
+	RestartAt int
+	// Amnesia crashes also lose persisted relations.
+	Amnesia bool
+}
+
+func (f Fault) String() string {
+	s := fmt.Sprintf("%s crashes at %d", f.Location, f.CrashAt)
+	if f.RestartAt > 0 {
+		s += fmt.Sprintf(" and restarts at %d", f.RestartAt)
+	}
+	if f.Amnesia {
+		s += " with amnesia"
+	}
+	return s
+}
+
+// covers reports whether the location is down at the given time because of this fault.
+func (f Fault) covers(time int) bool {
+	return time >= f.CrashAt && (f.RestartAt <= 0 || time < f.RestartAt)
+}
+
+// AddFault schedules a crash. Faults must be added before the timestep at which they crash, unless
+// the same fault is already scheduled (for example, by a restored checkpoint), in which case adding
+// it again does nothing.
+func (r *Runner) AddFault(f Fault) error {
+	if slices.Contains(r.faults, f) {
+		return nil
+	}
+	if f.CrashAt < 0 {
+		return fmt.Errorf("invalid fault (%s): crashes must happen at a non-negative timestep", f)
+	} else if f.RestartAt > 0 && f.RestartAt <= f.CrashAt {
+		return fmt.Errorf("invalid fault (%s): the restart must happen after the crash", f)
+	} else if f.CrashAt < r.clock(f.Location) {
+		return fmt.Errorf("invalid fault (%s): the runner is already at timestep %d", f, r.clock(f.Location))
+	}
+
+	for _, other := range r.faults {
+		if other.Location != f.Location {
+			continue
+		}
+		if f.covers(other.CrashAt) || other.covers(f.CrashAt) {
+			return fmt.Errorf("invalid fault (%s): it overlaps with another fault (%s)", f, other)
+		}
+	}
+
+	r.faults = append(r.faults, f)
+	if f.CrashAt == r.clock(f.Location) {
+		r.crash(f)
+	}
+	return nil
+}
+
+// down reports whether the location is crashed at the given time.
+func (r *Runner) down(loc string, time int) bool {
+	for _, f := range r.faults {
+		if f.Location == loc && f.covers(time) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyFaults crashes and restarts locations whose faults begin or end at the current timestep.
+func (r *Runner) applyFaults() {
+	for _, f := range r.faults {
+		if r.local != "" && f.Location != r.local {
+			continue
+		}
+		if f.CrashAt == r.currentTimestamp {
+			r.crash(f)
+		} else if f.RestartAt > 0 && f.RestartAt == r.currentTimestamp {
+			r.restart(f)
+		}
+	}
+}
+
+// crash discards every fact at the location while it is down, saving the persisted ones so they
+// can be recovered on restart.
+func (r *Runner) crash(f Fault) {
+	if r.recovered == nil {
+		r.recovered = map[string]map[*Relation][]*fact{}
+	}
+	saved := map[*Relation][]*fact{}
+
+	for _, rel := range r.relations {
+		if rel.readOnly {
+			continue
+		}
+		if rel.autoPersist && !f.Amnesia {
+			saved[rel] = rel.all(f.Location, f.CrashAt)
+		}
+
+		rel.removeIf(func(fa *fact) bool {
+			return fa.location == f.Location && f.covers(fa.timestamp)
+		})
+	}
+
+	r.recovered[f.Location] = saved
+}
+
+func (r *Runner) restart(f Fault) {
+	for rel, facts := range r.recovered[f.Location] {
+		for _, fa := range facts {
+			if rf := rel.insert(fa.data, f.Location, f.RestartAt); rf != nil {
+				rf.prov = &provenance{persistedFrom: fa, recovered: true}
+			}
+		}
+	}
+	delete(r.recovered, f.Location)
+}
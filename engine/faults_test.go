@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/rithvikp/dedalus/ast"
+)
+
+func TestFaults(t *testing.T) {
+	// A pings B every timestep. B persists every ping it has seen, and remembers the latest one.
+	const source = `
+peer("B").
+Src("x",A,0).
+ping(v,t,l',t') :- Src(v,l,t), peer(l'), choose((v,t),t')
+Seen(v,s,l,t) :- ping(v,s,l,t)
+latest(s,l,t) :- ping(v,s,l,t)`
+
+	tests := []struct {
+		msg    string
+		faults []Fault
+		facts  map[string][]*testFact
+	}{
+		{
+			msg:    "crash without restart",
+			faults: []Fault{{Location: "B", CrashAt: 2}},
+			facts: map[string][]*testFact{
+				"latest": {{[]string{"0"}, "B", 1}},
+				"Seen":   {{[]string{"x", "0"}, "B", 1}},
+			},
+		},
+		{
+			msg:    "crash and restart",
+			faults: []Fault{{Location: "B", CrashAt: 2, RestartAt: 4}},
+			facts: map[string][]*testFact{
+				"latest": {{[]string{"0"}, "B", 1}, {[]string{"3"}, "B", 4}},
+				"Seen": {
+					{[]string{"x", "0"}, "B", 1},
+					{[]string{"x", "0"}, "B", 4}, {[]string{"x", "3"}, "B", 4},
+				},
+			},
+		},
+		{
+			msg:    "amnesia crash",
+			faults: []Fault{{Location: "B", CrashAt: 2, RestartAt: 4, Amnesia: true}},
+			facts: map[string][]*testFact{
+				"latest": {{[]string{"0"}, "B", 1}, {[]string{"3"}, "B", 4}},
+				"Seen":   {{[]string{"x", "0"}, "B", 1}, {[]string{"x", "3"}, "B", 4}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			p, err := ast.Parse(strings.NewReader(source))
+			if err != nil {
+				t.Errorf("unable to parse the program: %v", err)
+				return
+			}
+
+			r, err := NewRunner(p)
+			if err != nil {
+				t.Errorf("unable to initialize the runner: %v", err)
+				return
+			}
+			r.SetDerivationLog(nil)
+			r.SetNetworkModel(FixedDelay(1))
+			for _, f := range tt.faults {
+				if err := r.AddFault(f); err != nil {
+					t.Errorf("unable to add a fault: %v", err)
+					return
+				}
+			}
+
+			for i := 0; i < 5; i++ {
+				r.Step()
+			}
+
+			for rel, want := range tt.facts {
+				// Only compare facts up to the last evaluated timestep
+				var got []*testFact
+				for _, f := range toTestFacts(r.relations[rel].allAcrossSpaceTime()) {
+					if f.timestamp < 5 {
+						got = append(got, f)
+					}
+				}
+				if diff := cmp.Diff(got, want, cmp.AllowUnexported(testFact{}), cmpopts.SortSlices(lessFacts)); diff != "" {
+					t.Errorf("fact diff for relation %q (-got, +want):\n%s", rel, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidFaults(t *testing.T) {
+	p, err := ast.Parse(strings.NewReader(`out(a,l,t) :- in(a,l,t)`))
+	if err != nil {
+		t.Fatalf("unable to parse the program: %v", err)
+	}
+	r, err := NewRunner(p)
+	if err != nil {
+		t.Fatalf("unable to initialize the runner: %v", err)
+	}
+
+	if err := r.AddFault(Fault{Location: "A", CrashAt: 3, RestartAt: 2}); err == nil {
+		t.Errorf("expected an error for a restart before the crash")
+	}
+	if err := r.AddFault(Fault{Location: "A", CrashAt: 3, RestartAt: 6}); err != nil {
+		t.Errorf("unexpected error adding a fault: %v", err)
+	}
+	if err := r.AddFault(Fault{Location: "A", CrashAt: 5}); err == nil {
+		t.Errorf("expected an error for overlapping faults")
+	}
+	if err := r.AddFault(Fault{Location: "B", CrashAt: 5}); err != nil {
+		t.Errorf("unexpected error adding a fault: %v", err)
+	}
+}
@@ -1,150 +1,297 @@
 package engine
 
 import (
-	"strconv"
+	"sort"
+
+	"golang.org/x/exp/slices"
 )
 
 type factNode struct {
-	lockedVars map[*Variable]string
+	lockedVars map[*Variable]value
+	// The fact matched for each body atom so far, in the order the atoms are joined.
+	facts []*fact
 }
 
-func join(rl *Rule, loc string, time int) [][]string {
-	var fringe []*factNode
-	rel := rl.body[0]
-	for _, f := range rel.all(loc, time) {
-		fn := &factNode{lockedVars: map[*Variable]string{
-			rl.bodyLocVar:  loc,
-			rl.bodyTimeVar: strconv.Itoa(time),
-		}}
+// joinPlan is the order in which a rule's body atoms are joined at a single location and timestep.
+// Negated atoms and conditions are checked as soon as every variable they read is bound.
+type joinPlan struct {
+	steps []joinStep
+	// Negated atoms and conditions which read variables bound by assignments (or by no atom), so
+	// are only checked once the assignments are made.
+	negated    []int
+	conditions []int
+}
 
-		consistent := true
-		for _, v := range rl.vars[rel.id] {
-			attrs := v.attrs[rel.id]
-			val := f.data[attrs[0].index]
-			if v == rl.bodyLocVar && val != loc || v == rl.bodyTimeVar && val != strconv.Itoa(time) {
-				consistent = false
-				break
-			}
+// joinStep joins a body atom (an index into the rule's body) and then checks negated atoms and
+// conditions (indexes into the rule's negated body and conditions).
+type joinStep struct {
+	atom       int
+	negated    []int
+	conditions []int
+}
 
-			for _, a := range attrs {
-				if f.data[a.index] != val {
-					consistent = false
-					break
-				}
-			}
-			fn.lockedVars[v] = val
+// planJoin orders the body atoms of a rule by their facts at the location and time. Starting from
+// the atom with the fewest facts, it repeatedly joins the smallest atom which shares a variable
+// bound by the start node or an earlier atom (falling back to the smallest of all, which is a cross
+// product), so that each atom is read through an index wherever possible. It returns nil if any
+// atom has no facts, in which case the rule derives nothing.
+func planJoin(rl *Rule, body, negated []*Relation, start *factNode, loc string, time int) *joinPlan {
+	sizes := make([]int, len(body))
+	for i, rel := range body {
+		if sizes[i] = rel.count(loc, time); sizes[i] == 0 {
+			return nil
 		}
+	}
 
-		if consistent {
-			fringe = append(fringe, fn)
+	bound := make(map[*Variable]bool, len(start.lockedVars))
+	for v := range start.lockedVars {
+		bound[v] = true
+	}
+	boundAll := func(vars []*Variable) bool {
+		for _, v := range vars {
+			if !bound[v] {
+				return false
+			}
 		}
+		return true
 	}
 
-	addChildren := func(node *factNode, rel *Relation) []*factNode {
-		var workingSet []*fact
-		first := true
-		for _, v := range rl.vars[rel.id] {
-			var matched []*fact
-			attrs := v.attrs[rel.id]
-			if val, ok := node.lockedVars[v]; ok {
-				matched, ok = rel.lookup(attrs[0].index, val, loc, time)
-				if !ok {
-					return nil
-				}
-			} else {
-				matched = rel.all(loc, time)
+	plan := &joinPlan{}
+	joined := make([]bool, len(body))
+	checkedNegated := make([]bool, len(negated))
+	checkedConditions := make([]bool, len(rl.conditions))
+	for len(plan.steps) < len(body) {
+		best, bestConnected := -1, false
+		for i, rel := range body {
+			if joined[i] {
+				continue
+			}
+			connected := false
+			for _, v := range rl.vars[rel.id] {
+				connected = connected || bound[v]
+			}
+			if best < 0 || connected && !bestConnected || connected == bestConnected && sizes[i] < sizes[best] {
+				best, bestConnected = i, connected
 			}
+		}
 
-			var newWorkingSet []*fact
-			if first {
-				newWorkingSet = make([]*fact, 0, len(matched))
+		joined[best] = true
+		for _, v := range rl.vars[body[best].id] {
+			bound[v] = true
+		}
+		step := joinStep{atom: best}
+		for i, rel := range negated {
+			if !checkedNegated[i] && boundAll(rl.vars[rel.id]) {
+				checkedNegated[i] = true
+				step.negated = append(step.negated, i)
 			}
-			for _, f1 := range matched {
-				val := f1.data[attrs[0].index]
-				consistent := true
-				for _, a := range attrs {
-					if f1.data[a.index] != val {
-						consistent = false
-						break
-					}
-				}
-				if !consistent {
-					continue
-				}
+		}
+		for i, cond := range rl.conditions {
+			if !checkedConditions[i] && boundAll(cond.vars) {
+				checkedConditions[i] = true
+				step.conditions = append(step.conditions, i)
+			}
+		}
+		plan.steps = append(plan.steps, step)
+	}
 
-				if first {
-					newWorkingSet = append(newWorkingSet, f1)
-					continue
-				}
+	for i, checked := range checkedNegated {
+		if !checked {
+			plan.negated = append(plan.negated, i)
+		}
+	}
+	for i, checked := range checkedConditions {
+		if !checked {
+			plan.conditions = append(plan.conditions, i)
+		}
+	}
+	return plan
+}
+
+// join evaluates the body of the given rule at a single location and timestep. Facts for each body
+// atom are read from the corresponding relation in body (and in negated, for negated atoms), which
+// allows callers to substitute a subset of a relation's facts (such as a delta during semi-naive
+// evaluation) for any atom.
+func join(rl *Rule, body, negated []*Relation, loc string, time int) []*derivation {
+	derivations, _ := joinFrom(rl, body, negated, startNode(rl, loc, time), loc, time)
+	return derivations
+}
+
+// startNode binds the body location and time variables of a rule, which every join starts from.
+func startNode(rl *Rule, loc string, time int) *factNode {
+	return &factNode{
+		lockedVars: map[*Variable]value{
+			rl.bodyLocVar:  locationValue(loc),
+			rl.bodyTimeVar: intValue(time),
+		},
+	}
+}
+
+// joinFailure is where a join stopped deriving anything: no fact matched a body atom (index is into
+// the body), a fact matched a negated atom (into the negated body) or a condition was false (into
+// the rule's conditions, or -1 if an assignment was undefined).
+type joinFailure struct {
+	reason WhyNotReason
+	index  int
+	// The number of body atoms joined before the failure, and a binding at that point.
+	joined int
+	node   *factNode
+}
 
-				// TODO: Switch to a hashed setup
-				for _, f2 := range workingSet {
-					if f1.equals(f2) {
-						newWorkingSet = append(newWorkingSet, f1)
-					}
+// joinFrom is join, starting from a node which may bind more variables than the body location and
+// time (see WhyNot). If nothing is derived, it also reports where the join stopped, for the first
+// binding which was rejected by the step that eliminated every binding.
+func joinFrom(rl *Rule, body, negated []*Relation, start *factNode, loc string, time int) ([]*derivation, *joinFailure) {
+	plan := planJoin(rl, body, negated, start, loc, time)
+	if plan == nil {
+		i := slices.IndexFunc(body, func(rel *Relation) bool { return rel.count(loc, time) == 0 })
+		return nil, &joinFailure{reason: ReasonMissingFact, index: i, node: start}
+	}
+
+	fringe := []*factNode{start}
+	for n, step := range plan.steps {
+		// Every node in the fringe has bound the same variables.
+		probe := newProbe(rl, body[step.atom], fringe[0])
+		var nextFringe []*factNode
+		var rejected *factNode
+		for _, parent := range fringe {
+			for _, fn := range addChildren(rl, parent, body[step.atom], probe, loc, time) {
+				if fn.satisfies(rl, step.negated, step.conditions, negated, loc, time) {
+					nextFringe = append(nextFringe, fn)
+				} else if rejected == nil {
+					rejected = fn
 				}
 			}
-			workingSet = newWorkingSet
-			first = false
 		}
-
-		var children []*factNode
-		for _, f := range workingSet {
-			fn := &factNode{lockedVars: map[*Variable]string{}}
-			for k, v := range node.lockedVars {
-				fn.lockedVars[k] = v
+		if len(nextFringe) == 0 {
+			if rejected == nil {
+				return nil, &joinFailure{reason: ReasonMissingFact, index: step.atom, joined: n, node: fringe[0]}
 			}
+			failure := rejected.rejection(rl, step.negated, step.conditions, negated, loc, time)
+			failure.joined = n + 1
+			return nil, failure
+		}
+		fringe = nextFringe
+	}
 
-			consistent := true
-			for _, v := range rl.vars[rel.id] {
-				attrs := v.attrs[rel.id]
-				val := f.data[attrs[0].index]
+	derivations := make([]*derivation, 0, len(fringe))
+	var failure *joinFailure
+	for _, fn := range fringe {
+		valueOf := fn.valueOf
 
-				fn.lockedVars[v] = val
+		if !fn.satisfies(rl, plan.negated, nil, negated, loc, time) {
+			if failure == nil {
+				failure = fn.rejection(rl, plan.negated, nil, negated, loc, time)
+			}
+			continue
+		}
+		if !fn.assign(rl) {
+			if failure == nil {
+				failure = &joinFailure{reason: ReasonFailedCondition, index: -1, node: fn}
 			}
-			if consistent {
-				children = append(children, fn)
+			continue
+		}
+		if !fn.satisfies(rl, nil, plan.conditions, negated, loc, time) {
+			if failure == nil {
+				failure = fn.rejection(rl, nil, plan.conditions, negated, loc, time)
 			}
+			continue
 		}
-		return children
-	}
 
-	for i := 1; i < len(rl.body); i++ {
-		rel := rl.body[i]
-		var nextFringe []*factNode
-		for _, parent := range fringe {
-			nextFringe = append(nextFringe, addChildren(parent, rel)...)
+		d := make([]value, rl.head.numAttrs())
+		for j, ht := range rl.headVarMapping {
+			d[j] = valueOf(ht.v)
 		}
 
-		fringe = nextFringe
+		// Provenance expects the supporting facts in the order of the body.
+		support := make([]*fact, len(body))
+		for i, step := range plan.steps {
+			support[step.atom] = fn.facts[i]
+		}
+
+		derivations = append(derivations, &derivation{
+			rule:    rl,
+			data:    d,
+			from:    loc,
+			to:      valueOf(rl.headLocVar).String(),
+			support: [][]*fact{support},
+		})
 	}
 
-	data := make([][]string, 0, len(fringe))
-	for _, fn := range fringe {
-		d := make([]string, rl.head.numAttrs()+1)
-		value := func(v *Variable) string {
-			if v == rl.bodyLocVar {
-				return loc
-			} else if v == rl.bodyTimeVar {
-				return strconv.Itoa(time)
-			} else {
-				return fn.lockedVars[v]
-			}
+	if len(derivations) == 0 {
+		failure.joined = len(plan.steps)
+		return nil, failure
+	}
+	return derivations, nil
+}
+
+// probe is how the facts of an atom are looked up: by the attributes which hold variables bound by
+// earlier atoms (in increasing order), along with those variables.
+type probe struct {
+	attrs []int
+	vars  []*Variable
+}
+
+func newProbe(rl *Rule, rel *Relation, node *factNode) probe {
+	var p probe
+	for _, v := range rl.vars[rel.id] {
+		if _, ok := node.lockedVars[v]; !ok {
+			continue
+		}
+		for _, a := range v.attrs[rel.id] {
+			p.attrs = append(p.attrs, a.index)
+			p.vars = append(p.vars, v)
+		}
+	}
+	sort.Sort(p)
+	return p
+}
+
+func (p probe) Len() int           { return len(p.attrs) }
+func (p probe) Less(i, j int) bool { return p.attrs[i] < p.attrs[j] }
+func (p probe) Swap(i, j int) {
+	p.attrs[i], p.attrs[j] = p.attrs[j], p.attrs[i]
+	p.vars[i], p.vars[j] = p.vars[j], p.vars[i]
+}
+
+// addChildren extends the node with every fact in rel consistent with its bound variables. Facts
+// are looked up by every bound attribute at once (through a composite index if there are several),
+// and only scanned in full if the atom shares no variables with the node.
+func addChildren(rl *Rule, node *factNode, rel *Relation, p probe, loc string, time int) []*factNode {
+	var candidates []*fact
+	switch len(p.attrs) {
+	case 0:
+		candidates = rel.all(loc, time)
+	case 1:
+		matched, ok := rel.lookup(p.attrs[0], node.lockedVars[p.vars[0]], loc, time)
+		if !ok {
+			return nil
 		}
+		candidates = matched
+	default:
+		vals := make([]value, len(p.vars))
+		for i, v := range p.vars {
+			vals[i] = node.lockedVars[v]
+		}
+		matched, ok := rel.lookupAll(p.attrs, vals, loc, time)
+		if !ok {
+			return nil
+		}
+		candidates = matched
+	}
 
-		// TODO: There are many ways in which this section needs to be optimized (mainly in terms of
-		// short-circuiting)
+	vars := rl.vars[rel.id]
+	var children []*factNode
+	for _, f := range candidates {
+		// Bound attributes all match, but unbound variables repeated in the atom must be checked.
 		consistent := true
-		for _, rel := range rl.negatedBody {
-			nd := make([]string, rel.numAttrs())
-			for _, v := range rl.vars[rel.id] {
-				val := value(v)
-				for _, a := range v.attrs[rel.id] {
-					nd[a.index] = val
-				}
+		for _, v := range vars {
+			attrs := v.attrs[rel.id]
+			val := f.data[attrs[0].index]
+			for _, a := range attrs {
+				consistent = consistent && f.data[a.index] == val
 			}
-			if rel.contains(nd, loc, time) {
-				consistent = false
+			if !consistent {
 				break
 			}
 		}
@@ -152,27 +299,74 @@ func join(rl *Rule, loc string, time int) [][]string {
 			continue
 		}
 
-		for _, a := range rl.assignments {
-			fn.lockedVars[a.v] = a.e.eval(value)
+		fn := &factNode{
+			lockedVars: make(map[*Variable]value, len(node.lockedVars)+len(vars)),
+			facts:      append(slices.Clone(node.facts), f),
+		}
+		for k, v := range node.lockedVars {
+			fn.lockedVars[k] = v
+		}
+		for _, v := range vars {
+			fn.lockedVars[v] = f.data[v.attrs[rel.id][0].index]
 		}
+		children = append(children, fn)
+	}
+	return children
+}
 
-		for _, cond := range rl.conditions {
-			if !cond.eval(value) {
-				consistent = false
-				break
+func (fn *factNode) valueOf(v *Variable) value {
+	return fn.lockedVars[v]
+}
+
+// assign binds the variables assigned by the rule, and reports false if an assignment is undefined
+// (such as a division by zero), in which case the binding derives nothing.
+func (fn *factNode) assign(rl *Rule) bool {
+	for _, a := range rl.assignments {
+		v, ok := a.e.eval(fn.valueOf)
+		if !ok {
+			return false
+		}
+		fn.lockedVars[a.v] = v
+	}
+	return true
+}
+
+// satisfies reports whether none of the given negated atoms hold and all of the given conditions
+// do, for the node's bindings.
+func (fn *factNode) satisfies(rl *Rule, negatedAtoms, conditions []int, negated []*Relation, loc string, time int) bool {
+	for _, i := range negatedAtoms {
+		rel := negated[i]
+		nd := make([]value, rel.numAttrs())
+		for _, v := range rl.vars[rel.id] {
+			val := fn.valueOf(v)
+			for _, a := range v.attrs[rel.id] {
+				nd[a.index] = val
 			}
 		}
-		if !consistent {
-			continue
+		if rel.contains(nd, loc, time) {
+			return false
 		}
+	}
 
-		for j, ht := range rl.headVarMapping {
-			d[j] = value(ht.v)
+	for _, i := range conditions {
+		if !rl.conditions[i].eval(fn.valueOf) {
+			return false
 		}
-
-		d[len(d)-1] = value(rl.headLocVar)
-		data = append(data, d)
 	}
+	return true
+}
 
-	return data
+// rejection reports which of the given negated atoms or conditions the node does not satisfy.
+func (fn *factNode) rejection(rl *Rule, negatedAtoms, conditions []int, negated []*Relation, loc string, time int) *joinFailure {
+	for _, i := range negatedAtoms {
+		if !fn.satisfies(rl, []int{i}, nil, negated, loc, time) {
+			return &joinFailure{reason: ReasonNegatedFact, index: i, node: fn}
+		}
+	}
+	for _, i := range conditions {
+		if !fn.satisfies(rl, nil, []int{i}, negated, loc, time) {
+			return &joinFailure{reason: ReasonFailedCondition, index: i, node: fn}
+		}
+	}
+	return nil
 }
@@ -0,0 +1,106 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rithvikp/dedalus/ast"
+)
+
+func TestPlanJoin(t *testing.T) {
+	tests := []struct {
+		msg    string
+		source string
+		// Each step of the plan for the first rule at L1 and timestep 0, as the joined atom followed by
+		// the negated atoms and conditions (by index) checked after it. A final "then" step lists
+		// those checked after the assignments. Nil if the rule cannot derive anything.
+		steps []string
+	}{
+		{
+			msg: "smallest atom first",
+			source: `
+out(a,c,l,t) :- big(a,b,l,t), small(b,c,l,t)
+big("1","2",L1,0).
+big("2","2",L1,0).
+big("3","2",L1,0).
+small("2","4",L1,0).`,
+			steps: []string{"small", "big"},
+		},
+		{
+			msg: "connected atoms before cross products",
+			source: `
+out(a,c,x,l,t) :- a1(a,b,l,t), u(x,l,t), b1(b,c,l,t)
+a1("1","2",L1,0).
+u("x",L1,0).
+u("y",L1,0).
+b1("2","3",L1,0).
+b1("2","4",L1,0).
+b1("2","5",L1,0).`,
+			steps: []string{"a1", "b1", "u"},
+		},
+		{
+			msg: "negation and conditions as soon as they are bound",
+			source: `
+out(a,c,l,t) :- q(b,c,l,t), p(a,b,l,t), not r(a,l,t), a != 1, c != 2
+p("1","2",L1,0).
+q("2","3",L1,0).
+q("2","4",L1,0).
+r("5",L1,0).`,
+			steps: []string{"p not r cond 0", "q cond 1"},
+		},
+		{
+			msg: "conditions on assigned variables",
+			source: `
+out(a,n,l,t) :- p(a,b,l,t), n = b+1, n > 2, a > 0
+p("1","2",L1,0).`,
+			steps: []string{"p cond 1", "then cond 0"},
+		},
+		{
+			msg: "empty atom",
+			source: `
+out(a,c,l,t) :- p(a,b,l,t), q(b,c,l,t)
+p("1","2",L1,0).`,
+			steps: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		p, err := ast.Parse(strings.NewReader(tt.source))
+		if err != nil {
+			t.Errorf("%s: unable to parse the program: %v", tt.msg, err)
+			continue
+		}
+		s, err := New(p)
+		if err != nil {
+			t.Errorf("%s: unable to initialize the state: %v", tt.msg, err)
+			continue
+		}
+
+		rl := s.rules[0]
+		plan := planJoin(rl, rl.body, rl.negatedBody, startNode(rl, "L1", 0), "L1", 0)
+		var steps []string
+		if plan != nil {
+			describe := func(s string, negated, conditions []int) string {
+				for _, i := range negated {
+					s += " not " + rl.negatedBody[i].id
+				}
+				for _, i := range conditions {
+					s += fmt.Sprintf(" cond %d", i)
+				}
+				return s
+			}
+			for _, step := range plan.steps {
+				steps = append(steps, describe(rl.body[step.atom].id, step.negated, step.conditions))
+			}
+			if len(plan.negated) > 0 || len(plan.conditions) > 0 {
+				steps = append(steps, describe("then", plan.negated, plan.conditions))
+			}
+		}
+
+		if diff := cmp.Diff(tt.steps, steps); diff != "" {
+			t.Errorf("%s: unexpected plan (-want +got):\n%s", tt.msg, diff)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Message is a fact derived by an async rule which is in flight between two locations.
+type Message struct {
+	Rule     string
+	Relation string
+	Tuple    []string
+	From     string
+	To       string
+	SentAt   int
+}
+
+// Link is a directed connection between two locations.
+type Link struct {
+	From string
+	To   string
+}
+
+// NetworkModel decides the fate of every message derived by an async rule.
+type NetworkModel interface {
+	// Deliver returns the delays (in timesteps) after which copies of the message arrive. Returning
+	// no delays drops the message and returning more than one duplicates it. Delays must be at least
+	// 1, as async rules deliver strictly into the future; smaller delays are treated as 1.
+	//
+	// rng is seeded from the runner's seed and the message itself, so models which only draw from it
+	// are reproducible for a given seed regardless of the order in which messages are derived.
+	Deliver(m Message, rng *rand.Rand) []int
+}
+
+// FixedDelay delivers every message after the same delay.
+type FixedDelay int
+
+func (d FixedDelay) Deliver(_ Message, _ *rand.Rand) []int {
+	return []int{int(d)}
+}
+
+// UniformDelay delivers every message after a delay drawn uniformly from [Min, Max].
+type UniformDelay struct {
+	Min int
+	Max int
+}
+
+func (d UniformDelay) Deliver(_ Message, rng *rand.Rand) []int {
+	if d.Max <= d.Min {
+		return []int{d.Min}
+	}
+	return []int{d.Min + rng.Intn(d.Max-d.Min+1)}
+}
+
+// LatencyMatrix delivers messages after a fixed per-link delay, falling back to Default (or the
+// default model, if it is nil) for links which are not listed.
+type LatencyMatrix struct {
+	Latencies map[Link]int
+	Default   NetworkModel
+}
+
+func (m LatencyMatrix) Deliver(msg Message, rng *rand.Rand) []int {
+	if d, ok := m.Latencies[Link{From: msg.From, To: msg.To}]; ok {
+		return []int{d}
+	}
+	return orDefault(m.Default).Deliver(msg, rng)
+}
+
+// LossyLink wraps another model (the default model, if Model is nil), dropping or duplicating
+// messages on some links with the given probabilities. If Links is empty, every link is lossy.
+type LossyLink struct {
+	Model         NetworkModel
+	Links         map[Link]bool
+	DropRate      float64
+	DuplicateRate float64
+}
+
+func (l LossyLink) Deliver(msg Message, rng *rand.Rand) []int {
+	model := orDefault(l.Model)
+	delays := model.Deliver(msg, rng)
+	if len(l.Links) > 0 && !l.Links[Link{From: msg.From, To: msg.To}] {
+		return delays
+	}
+
+	if rng.Float64() < l.DropRate {
+		return nil
+	}
+	if rng.Float64() < l.DuplicateRate {
+		delays = append(delays, model.Deliver(msg, rng)...)
+	}
+	return delays
+}
+
+// defaultNetworkModel is used by runners which have not been given a network model.
+var defaultNetworkModel NetworkModel = UniformDelay{Min: 1, Max: 8}
+
+// orDefault returns the model, or the default model if it is nil.
+func orDefault(m NetworkModel) NetworkModel {
+	if m == nil {
+		return defaultNetworkModel
+	}
+	return m
+}
+
+// SetNetworkModel sets the model consulted for every async derivation. A nil model restores the
+// default.
+func (r *Runner) SetNetworkModel(m NetworkModel) {
+	r.network = orDefault(m)
+}
+
+// SetSeed sets the seed from which the randomness given to the network model is derived. Runs with
+// the same program, model and seed are identical.
+func (r *Runner) SetSeed(seed int64) {
+	r.seed = seed
+}
+
+// send delivers an async derivation according to the runner's network model (unless it crosses a
+// partition or is sent to another node).
+func (r *Runner) send(d *derivation) {
+	if r.intercept(d) || r.remote(d) {
+		return
+	}
+
+	for _, delay := range r.delays(d) {
+		r.deliver(d, r.currentTimestamp+delay)
+	}
+}
+
+// message describes an async derivation sent at the current timestep.
+func (r *Runner) message(d *derivation) Message {
+	return Message{
+		Rule:     d.rule.id,
+		Relation: d.rule.head.id,
+		Tuple:    valueStrings(d.data),
+		From:     d.from,
+		To:       d.to,
+		SentAt:   r.currentTimestamp,
+	}
+}
+
+// delays consults the network model for the delays after which copies of an async derivation
+// arrive.
+func (r *Runner) delays(d *derivation) []int {
+	msg := r.message(d)
+	delays := r.network.Deliver(msg, r.messageRand(msg))
+	for i := range delays {
+		if delays[i] < 1 {
+			delays[i] = 1
+		}
+	}
+	return delays
+}
+
+// messageRand returns a source of randomness determined by the runner's seed and the message.
+func (r *Runner) messageRand(msg Message) *rand.Rand {
+	h := sha1.New()
+	h.Write([]byte(strconv.FormatInt(r.seed, 10)))
+	for _, s := range []string{msg.Rule, strings.Join(msg.Tuple, ";"), msg.From, msg.To, strconv.Itoa(msg.SentAt)} {
+		h.Write([]byte{0})
+		h.Write([]byte(s))
+	}
+
+	return rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(h.Sum(nil)[:8]))))
+}
@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/rithvikp/dedalus/ast"
+)
+
+// increasingDelay delays each copy of a message by one more timestep than the previous copy.
+type increasingDelay map[string]int
+
+func (d increasingDelay) Deliver(m Message, _ *rand.Rand) []int {
+	k := strings.Join(m.Tuple, ",")
+	d[k]++
+	return []int{d[k]}
+}
+
+const networkSource = `
+peer("B").
+out("1",A,0).
+out("2",A,0).
+recv(v,l',t') :- out(v,l,t), peer(l'), choose((v),t')`
+
+func runNetwork(t *testing.T, model NetworkModel, seed int64, steps int) []*testFact {
+	t.Helper()
+	p, err := ast.Parse(strings.NewReader(networkSource))
+	if err != nil {
+		t.Fatalf("unable to parse the program: %v", err)
+	}
+
+	r, err := NewRunner(p)
+	if err != nil {
+		t.Fatalf("unable to initialize the runner: %v", err)
+	}
+	r.SetDerivationLog(nil)
+	r.SetNetworkModel(model)
+	r.SetSeed(seed)
+
+	for i := 0; i < steps; i++ {
+		r.Step()
+	}
+	return toTestFacts(r.relations["recv"].allAcrossSpaceTime())
+}
+
+func TestNetworkModels(t *testing.T) {
+	tests := []struct {
+		msg   string
+		model NetworkModel
+		facts []*testFact
+	}{
+		{
+			msg:   "fixed delay",
+			model: FixedDelay(2),
+			facts: []*testFact{{[]string{"1"}, "B", 2}, {[]string{"2"}, "B", 2}},
+		},
+		{
+			msg:   "latency matrix",
+			model: LatencyMatrix{Latencies: map[Link]int{{From: "A", To: "B"}: 3}, Default: FixedDelay(1)},
+			facts: []*testFact{{[]string{"1"}, "B", 3}, {[]string{"2"}, "B", 3}},
+		},
+		{
+			msg:   "latency matrix default",
+			model: LatencyMatrix{Latencies: map[Link]int{{From: "B", To: "A"}: 3}, Default: FixedDelay(1)},
+			facts: []*testFact{{[]string{"1"}, "B", 1}, {[]string{"2"}, "B", 1}},
+		},
+		{
+			msg:   "delays are at least one",
+			model: FixedDelay(0),
+			facts: []*testFact{{[]string{"1"}, "B", 1}, {[]string{"2"}, "B", 1}},
+		},
+		{
+			msg:   "lossy link",
+			model: LossyLink{Model: FixedDelay(1), DropRate: 1},
+			facts: []*testFact{},
+		},
+		{
+			msg:   "lossy link on another link",
+			model: LossyLink{Model: FixedDelay(1), Links: map[Link]bool{{From: "B", To: "A"}: true}, DropRate: 1},
+			facts: []*testFact{{[]string{"1"}, "B", 1}, {[]string{"2"}, "B", 1}},
+		},
+		{
+			msg:   "duplicating link",
+			model: LossyLink{Model: increasingDelay{}, DuplicateRate: 1},
+			facts: []*testFact{
+				{[]string{"1"}, "B", 1}, {[]string{"1"}, "B", 2},
+				{[]string{"2"}, "B", 1}, {[]string{"2"}, "B", 2},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			got := runNetwork(t, tt.model, 0, 6)
+			if diff := cmp.Diff(got, tt.facts, cmp.AllowUnexported(testFact{}), cmpopts.SortSlices(lessFacts), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("fact diff for relation recv (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNilNetworkModels(t *testing.T) {
+	// Wrapped models which are nil fall back to the default model, which delays messages by 1 to 8
+	// timesteps.
+	for _, model := range []NetworkModel{nil, LatencyMatrix{}, LossyLink{}} {
+		got := runNetwork(t, model, 0, 10)
+		if len(got) != 2 {
+			t.Errorf("expected both messages to be delivered with %#v, got %v", model, got)
+		}
+		for _, f := range got {
+			if f.timestamp < 1 || f.timestamp > 8 {
+				t.Errorf("expected %v to be delivered after 1 to 8 timesteps with %#v", f, model)
+			}
+		}
+	}
+}
+
+func TestNetworkSeeds(t *testing.T) {
+	model := UniformDelay{Min: 1, Max: 8}
+	first := runNetwork(t, model, 7, 10)
+	if diff := cmp.Diff(runNetwork(t, model, 7, 10), first, cmp.AllowUnexported(testFact{}), cmpopts.SortSlices(lessFacts)); diff != "" {
+		t.Errorf("runs with the same seed differ (-got, +want):\n%s", diff)
+	}
+
+	for seed := int64(0); seed < 20; seed++ {
+		if !cmp.Equal(runNetwork(t, model, seed, 10), first, cmp.AllowUnexported(testFact{}), cmpopts.SortSlices(lessFacts)) {
+			return
+		}
+	}
+	t.Errorf("every seed produced the same deliveries")
+}
@@ -0,0 +1,320 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Node runs a single location of a program as its own process, exchanging facts with the processes
+// running the other locations over TCP. Only rules whose body is at the node's location are
+// evaluated, and every fact derived for another location is sent to the process running it, where
+// it arrives at the next timestep that process executes (or the one after, for rules deriving facts
+// for the next timestep). Timesteps are not synchronized across nodes: each node executes one every
+// tick.
+//
+// Messages which cannot be received (such as those for relations the program does not have) or
+// sent (to locations outside the cluster) are reported to the error log and dropped, so that one
+// misbehaving peer cannot stop the others.
+type Node struct {
+	r   *Runner
+	loc string
+	// The address of the process running each location.
+	cluster map[string]string
+
+	ln net.Listener
+	wg sync.WaitGroup
+	// Where dropped messages are reported. Only accessed by Run.
+	errLog io.Writer
+
+	mu     sync.Mutex
+	inbox  []inboxMessage
+	conns  map[net.Conn]bool
+	closed bool
+
+	// Messages waiting to be sent to each location, and the connections they are sent on. Only
+	// accessed by Run.
+	outbox map[string][]Message
+	peers  map[string]*peer
+}
+
+type peer struct {
+	conn net.Conn
+	enc  *json.Encoder
+}
+
+// NewNode starts listening for facts sent to the given location, at its address in the cluster
+// (which maps every location to a TCP address). The runner must not have been executed yet, and only
+// keeps the facts it was given at the node's location.
+func NewNode(r *Runner, loc string, cluster map[string]string) (*Node, error) {
+	addr, ok := cluster[loc]
+	if !ok {
+		return nil, fmt.Errorf("the location %q is not in the cluster", loc)
+	} else if r.executed {
+		return nil, errors.New("the runner has already been executed")
+	} else if r.scheduler != nil {
+		return nil, errors.New("nodes keep their own clocks, so the runner cannot have a scheduler")
+	}
+	for _, l := range r.sortedLocations() {
+		if _, ok := cluster[l]; !ok {
+			return nil, fmt.Errorf("the program has facts at %q, which is not in the cluster", l)
+		}
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Node{
+		r:       r,
+		loc:     loc,
+		cluster: cluster,
+		ln:      ln,
+		errLog:  os.Stderr,
+		conns:   map[net.Conn]bool{},
+		outbox:  map[string][]Message{},
+		peers:   map[string]*peer{},
+	}
+	r.local = loc
+	r.ship = func(d *derivation) {
+		n.outbox[d.to] = append(n.outbox[d.to], r.message(d))
+	}
+	for _, rel := range r.relations {
+		if !rel.readOnly {
+			rel.removeIf(func(f *fact) bool {
+				return f.location != loc
+			})
+		}
+	}
+	r.locations = map[string]struct{}{loc: {}}
+
+	n.wg.Add(1)
+	go n.accept()
+	return n, nil
+}
+
+// Addr returns the address the node is listening on.
+func (n *Node) Addr() net.Addr {
+	return n.ln.Addr()
+}
+
+// SetErrorLog sets where messages which are dropped because they cannot be received or sent are
+// reported (stderr by default). A nil writer disables reporting. It must not be called while Run is
+// running.
+func (n *Node) SetErrorLog(w io.Writer) {
+	n.errLog = w
+}
+
+func (n *Node) logf(format string, args ...interface{}) {
+	if n.errLog != nil {
+		fmt.Fprintf(n.errLog, format+"\n", args...)
+	}
+}
+
+// Run executes a timestep every tick until the context is done or, if steps is positive, that many
+// timesteps have been executed. Facts received since the previous timestep are added to the
+// location before each timestep is executed, and the facts it derives for other locations are sent
+// once it is done. Facts for locations which cannot be reached yet are kept and retried every tick.
+func (n *Node) Run(ctx context.Context, tick time.Duration, steps int) error {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for i := 0; steps <= 0 || i < steps; i++ {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		n.receive()
+		n.r.Step()
+		n.send()
+	}
+	return nil
+}
+
+// Close stops listening and closes every connection. It must not be called while Run is running.
+func (n *Node) Close() error {
+	n.mu.Lock()
+	n.closed = true
+	for conn := range n.conns {
+		conn.Close()
+	}
+	n.mu.Unlock()
+
+	err := n.ln.Close()
+	for _, p := range n.peers {
+		p.conn.Close()
+	}
+	n.wg.Wait()
+	return err
+}
+
+func (n *Node) accept() {
+	defer n.wg.Done()
+	for {
+		conn, err := n.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		n.mu.Lock()
+		if n.closed {
+			n.mu.Unlock()
+			conn.Close()
+			return
+		}
+		n.conns[conn] = true
+		n.mu.Unlock()
+
+		n.wg.Add(1)
+		go n.read(conn)
+	}
+}
+
+// read queues every message received on the connection, one JSON object per line.
+func (n *Node) read(conn net.Conn) {
+	defer n.wg.Done()
+	defer func() {
+		n.mu.Lock()
+		delete(n.conns, conn)
+		n.mu.Unlock()
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		// Malformed messages are queued as errors, so that they are reported by Run.
+		var m Message
+		err := json.Unmarshal(scanner.Bytes(), &m)
+		n.mu.Lock()
+		if err != nil {
+			n.inbox = append(n.inbox, inboxMessage{err: fmt.Errorf("malformed message from %s: %v", conn.RemoteAddr(), err)})
+		} else {
+			n.inbox = append(n.inbox, inboxMessage{m: m})
+		}
+		n.mu.Unlock()
+	}
+}
+
+type inboxMessage struct {
+	m   Message
+	err error
+}
+
+// receive adds every queued message to the location, dropping (and reporting) those which are
+// invalid.
+func (n *Node) receive() {
+	n.mu.Lock()
+	inbox := n.inbox
+	n.inbox = nil
+	n.mu.Unlock()
+
+	for _, im := range inbox {
+		if im.err != nil {
+			n.logf("Dropped a %v", im.err)
+			continue
+		}
+		if err := n.r.receive(im.m); err != nil {
+			n.logf("Dropped an invalid message from %s: %v", im.m.From, err)
+		}
+	}
+}
+
+// send sends every queued message to its location, keeping those which cannot be sent yet. Messages
+// are only removed from the outbox once they have been written to the connection, so when a
+// connection fails partway through, only the remaining messages are retried (on a new connection).
+// Messages written just before a connection fails may still be lost.
+func (n *Node) send() {
+	for to, msgs := range n.outbox {
+		if _, ok := n.cluster[to]; !ok {
+			n.logf("Dropped %d messages for %s, which is not in the cluster", len(msgs), to)
+			delete(n.outbox, to)
+			continue
+		}
+
+		sent, err := n.sendTo(to, msgs)
+		if err != nil {
+			if p, ok := n.peers[to]; ok {
+				p.conn.Close()
+				delete(n.peers, to)
+			}
+			n.outbox[to] = msgs[sent:]
+			continue
+		}
+		delete(n.outbox, to)
+	}
+}
+
+// sendTo writes messages to the location (which must be in the cluster), returning how many were
+// written.
+func (n *Node) sendTo(to string, msgs []Message) (int, error) {
+	p, ok := n.peers[to]
+	if !ok {
+		conn, err := net.Dial("tcp", n.cluster[to])
+		if err != nil {
+			return 0, err
+		}
+		p = &peer{conn: conn, enc: json.NewEncoder(conn)}
+		n.peers[to] = p
+	}
+
+	for i, m := range msgs {
+		if err := p.enc.Encode(m); err != nil {
+			return i, err
+		}
+	}
+	return len(msgs), nil
+}
+
+// remote ships a derivation for another location (to the process running it, or to its own clock),
+// returning false if the location is local or the runner evaluates every location at once.
+func (r *Runner) remote(d *derivation) bool {
+	if r.local == "" || d.to == r.local {
+		return false
+	}
+	r.ship(d)
+	return true
+}
+
+// receive adds a fact sent by another location. Like facts delivered with per-location clocks (see
+// SetScheduler), it arrives at the current timestep, unless it was derived by a rule deriving facts
+// for the next timestep, in which case it arrives at the one after.
+func (r *Runner) receive(m Message) error {
+	var rl *Rule
+	for _, other := range r.rules {
+		if other.id == m.Rule {
+			rl = other
+			break
+		}
+	}
+	if rl == nil {
+		return fmt.Errorf("the rule %q does not exist", m.Rule)
+	} else if rl.head.id != m.Relation {
+		return fmt.Errorf("rule %s derives %q, not %q", m.Rule, rl.head.id, m.Relation)
+	}
+	rel := rl.head
+	data, err := rel.parseRow(m.Tuple)
+	if err != nil {
+		return err
+	}
+
+	time := r.currentTimestamp
+	if rl.timeModel == TimeModelSuccessor {
+		time++
+	}
+	if f := rel.insert(data, r.local, time); f != nil {
+		f.prov = &provenance{receivedFrom: m.From}
+		r.emit(rel, f)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rithvikp/dedalus/ast"
+)
+
+func TestNodes(t *testing.T) {
+	// A pings B, which acknowledges every ping.
+	const program = `
+.decl peer(node: location) edb
+peer("B").
+Src("x",A,0).
+ping(v,l,l',t') :- Src(v,l,t), peer(l'), choose((v,l),t')
+ack(v,s,t') :- ping(v,s,l,t), choose((v),t')
+Acked(v,l,t) :- ack(v,l,t)
+`
+
+	// Every node listens on a free port, which is only known once it has started.
+	cluster := map[string]string{"A": "127.0.0.1:0", "B": "127.0.0.1:0"}
+	nodes := map[string]*Node{}
+	runners := map[string]*Runner{}
+	for _, loc := range []string{"A", "B"} {
+		p, err := ast.Parse(strings.NewReader(program))
+		if err != nil {
+			t.Fatalf("unable to parse the program: %v", err)
+		}
+		r, err := NewRunner(p)
+		if err != nil {
+			t.Fatalf("unable to initialize the runner: %v", err)
+		}
+		r.SetDerivationLog(nil)
+
+		n, err := NewNode(r, loc, cluster)
+		if err != nil {
+			t.Fatalf("unable to start node %s: %v", loc, err)
+		}
+		defer n.Close()
+		cluster[loc] = n.Addr().String()
+		nodes[loc], runners[loc] = n, r
+	}
+
+	const steps = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, len(nodes))
+	for _, n := range nodes {
+		wg.Add(1)
+		go func(n *Node) {
+			defer wg.Done()
+			errs <- n.Run(context.Background(), 5*time.Millisecond, steps)
+		}(n)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unable to run a node: %v", err)
+		}
+	}
+
+	// A only holds facts at A, and B only at B.
+	if facts, _ := runners["A"].Query("Src", nil, "", -1); len(facts) != steps+1 {
+		t.Errorf("expected A to hold Src at every timestep, but it holds %v", facts)
+	}
+	if facts, _ := runners["B"].Query("Src", nil, "", -1); len(facts) != 0 {
+		t.Errorf("expected B to hold no Src facts, but it holds %v", facts)
+	}
+
+	acked, err := runners["A"].Query("Acked", nil, "A", steps)
+	if err != nil {
+		t.Fatalf("unable to query A: %v", err)
+	}
+	want := []Fact{{Relation: "Acked", Tuple: []string{"x"}, Location: "A", Timestamp: steps}}
+	if diff := cmp.Diff(want, acked); diff != "" {
+		t.Errorf("unexpected acknowledgements at A (-want +got):\n%s", diff)
+	}
+
+	pings, err := runners["B"].Query("ping", []string{"x", "A"}, "B", -1)
+	if err != nil || len(pings) == 0 {
+		t.Fatalf("expected B to receive a ping (error: %v)", err)
+	}
+	d, err := runners["B"].Why("ping", pings[0].Tuple, "B", pings[0].Timestamp)
+	if err != nil {
+		t.Fatalf("unable to explain a ping: %v", err)
+	}
+	if d.ReceivedFrom != "A" {
+		t.Errorf("expected the ping to be received from A, but got:\n%s", d)
+	}
+}
+
+func TestNodeInvalidMessages(t *testing.T) {
+	const program = `
+Src("x",A,0).
+out(v,l,t) :- Src(v,l,t)
+`
+	newTestNode := func(t *testing.T, cluster map[string]string) (*Node, error) {
+		t.Helper()
+		p, err := ast.Parse(strings.NewReader(program))
+		if err != nil {
+			t.Fatalf("unable to parse the program: %v", err)
+		}
+		r, err := NewRunner(p)
+		if err != nil {
+			t.Fatalf("unable to initialize the runner: %v", err)
+		}
+		r.SetDerivationLog(nil)
+		return NewNode(r, "B", cluster)
+	}
+
+	if _, err := newTestNode(t, map[string]string{"B": "127.0.0.1:0"}); err == nil {
+		t.Errorf("expected starting a node without every location in the program to fail")
+	}
+
+	n, err := newTestNode(t, map[string]string{"A": "127.0.0.1:0", "B": "127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("unable to start the node: %v", err)
+	}
+	defer n.Close()
+	var log bytes.Buffer
+	n.SetErrorLog(&log)
+
+	conn, err := net.Dial("tcp", n.Addr().String())
+	if err != nil {
+		t.Fatalf("unable to connect to the node: %v", err)
+	}
+	defer conn.Close()
+	for _, line := range []string{
+		`not json`,
+		`{"Rule":"0","Relation":"missing","Tuple":["x"],"From":"A","To":"B"}`,
+		`{"Rule":"0","Relation":"out","Tuple":["x","y"],"From":"A","To":"B"}`,
+		`{"Rule":"0","Relation":"out","Tuple":["y"],"From":"A","To":"B"}`,
+	} {
+		if _, err := conn.Write([]byte(line + "\n")); err != nil {
+			t.Fatalf("unable to send a message: %v", err)
+		}
+	}
+
+	// Give the node time to read every message before its last timestep.
+	if err := n.Run(context.Background(), 20*time.Millisecond, 5); err != nil {
+		t.Fatalf("expected the node to keep running, but it failed: %v", err)
+	}
+	if got := strings.Count(log.String(), "Dropped"); got != 3 {
+		t.Errorf("expected 3 messages to be dropped, but the log is:\n%s", log.String())
+	}
+	if facts, _ := n.r.Query("out", []string{"y"}, "B", -1); len(facts) == 0 {
+		t.Errorf("expected the valid message to be received")
+	}
+}
@@ -0,0 +1,269 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Format is a way of rendering the facts in relations.
+type Format int
+
+const (
+	// FormatTable is a human-readable table, as printed by the REPL (titled by the relation's name
+	// when there are several).
+	FormatTable Format = iota
+	// FormatJSON is an array with an object for each relation, holding its columns and an array of
+	// its facts.
+	FormatJSON
+	// FormatJSONL is a JSON object for each fact, one per line, including the relation's name.
+	FormatJSONL
+	// FormatCSV and FormatTSV write a header row of column names followed by a row for each fact.
+	// Several relations are separated by an empty line.
+	FormatCSV
+	FormatTSV
+)
+
+var formatNames = map[Format]string{
+	FormatTable: "table",
+	FormatJSON:  "json",
+	FormatJSONL: "jsonl",
+	FormatCSV:   "csv",
+	FormatTSV:   "tsv",
+}
+
+func (f Format) String() string {
+	return formatNames[f]
+}
+
+// ParseFormat converts the name of a format (table, json, jsonl, csv or tsv) into a Format.
+func ParseFormat(name string) (Format, error) {
+	for f, n := range formatNames {
+		if n == name {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown format %q (expected table, json, jsonl, csv or tsv)", name)
+}
+
+// WriteRelations renders the facts in the named relations to w, or every relation if no names are
+// given. Each fact is written as its attributes (named as declared, or A0, A1, ...) followed by
+// its location and timestamp, unless the relation is read-only.
+func (r *Runner) WriteRelations(w io.Writer, format Format, names ...string) error {
+	var rels []*Relation
+	if len(names) == 0 {
+		for _, rel := range r.relations {
+			rels = append(rels, rel)
+		}
+		sort.Slice(rels, func(i, j int) bool { return rels[i].id < rels[j].id })
+	}
+	for _, name := range names {
+		rel, ok := r.relations[name]
+		if !ok {
+			return fmt.Errorf("the relation %q does not exist", name)
+		}
+		rels = append(rels, rel)
+	}
+
+	switch format {
+	case FormatJSON:
+		return writeJSON(w, rels)
+	case FormatJSONL:
+		for _, rel := range rels {
+			if err := writeJSONL(w, rel); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for i, rel := range rels {
+		if i > 0 {
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		switch format {
+		case FormatTable:
+			if len(rels) > 1 {
+				fmt.Fprintln(w, rel.id)
+			}
+			err = writeTable(w, rel)
+		case FormatCSV:
+			err = writeDelimited(w, rel, ',')
+		case FormatTSV:
+			err = writeDelimited(w, rel, '\t')
+		default:
+			err = fmt.Errorf("unknown format %d", format)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// columns returns the names of the relation's columns, including its location and time.
+func (r *Relation) columns() []string {
+	cols := make([]string, r.numAttrs())
+	for i := range cols {
+		cols[i] = r.attrName(i)
+	}
+	if !r.readOnly {
+		cols = append(cols, "loc", "time")
+	}
+	return cols
+}
+
+// sortedFacts returns every fact in the relation, ordered by timestamp, location and then data.
+func (r *Relation) sortedFacts() []*fact {
+	facts := r.allAcrossSpaceTime()
+	key := func(f *fact) string {
+		return strings.Join(valueStrings(f.data), "\x00")
+	}
+	sort.Slice(facts, func(i, j int) bool {
+		if facts[i].timestamp != facts[j].timestamp {
+			return facts[i].timestamp < facts[j].timestamp
+		} else if facts[i].location != facts[j].location {
+			return facts[i].location < facts[j].location
+		}
+		return key(facts[i]) < key(facts[j])
+	})
+	return facts
+}
+
+// row returns the textual columns of a fact in the relation.
+func (r *Relation) row(f *fact) []string {
+	row := valueStrings(f.data)
+	if !r.readOnly {
+		row = append(row, f.location, fmt.Sprint(f.timestamp))
+	}
+	return row
+}
+
+// jsonValues returns the columns of a fact in the relation as JSON values, so numbers and bools are
+// not quoted.
+func (r *Relation) jsonValues(f *fact) []any {
+	vals := make([]any, 0, len(f.data)+2)
+	for _, v := range f.data {
+		switch v.typ {
+		case typeInt:
+			vals = append(vals, v.i)
+		case typeFloat:
+			vals = append(vals, v.f)
+		case typeBool:
+			vals = append(vals, v.b)
+		default:
+			vals = append(vals, v.s)
+		}
+	}
+	if !r.readOnly {
+		vals = append(vals, f.location, f.timestamp)
+	}
+	return vals
+}
+
+func writeTable(w io.Writer, rel *Relation) error {
+	tabw := new(tabwriter.Writer)
+	tabw.Init(w, 4, 8, 1, ' ', 0)
+
+	fmt.Fprint(tabw, "Idx\t")
+	for i := range rel.indexes {
+		fmt.Fprintf(tabw, "%s\t", rel.attrName(i))
+	}
+	fmt.Fprintln(tabw, "Loc\tTime")
+
+	for i, f := range rel.sortedFacts() {
+		fmt.Fprintf(tabw, "%d.\t", i+1)
+		for _, val := range f.data {
+			fmt.Fprintf(tabw, "%s\t", val.String())
+		}
+		fmt.Fprintf(tabw, "%s\t%d\n", f.location, f.timestamp)
+	}
+	return tabw.Flush()
+}
+
+func writeDelimited(w io.Writer, rel *Relation, delim rune) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = delim
+	if err := cw.Write(rel.columns()); err != nil {
+		return err
+	}
+	for _, f := range rel.sortedFacts() {
+		if err := cw.Write(rel.row(f)); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// jsonObject encodes the keys and values as a JSON object, preserving their order.
+func jsonObject(keys []string, vals []any) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		kb, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		vb, err := json.Marshal(vals[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(kb)
+		buf.WriteByte(':')
+		buf.Write(vb)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func writeJSONL(w io.Writer, rel *Relation) error {
+	keys := append([]string{"relation"}, rel.columns()...)
+	for _, f := range rel.sortedFacts() {
+		b, err := jsonObject(keys, append([]any{rel.id}, rel.jsonValues(f)...))
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s\n", b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, rels []*Relation) error {
+	type jsonRelation struct {
+		Relation string            `json:"relation"`
+		Columns  []string          `json:"columns"`
+		Facts    []json.RawMessage `json:"facts"`
+	}
+
+	out := make([]jsonRelation, 0, len(rels))
+	for _, rel := range rels {
+		jr := jsonRelation{Relation: rel.id, Columns: rel.columns(), Facts: []json.RawMessage{}}
+		for _, f := range rel.sortedFacts() {
+			b, err := jsonObject(jr.Columns, rel.jsonValues(f))
+			if err != nil {
+				return err
+			}
+			jr.Facts = append(jr.Facts, b)
+		}
+		out = append(out, jr)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rithvikp/dedalus/ast"
+)
+
+func TestWriteRelations(t *testing.T) {
+	const program = `
+.decl score(player: string, points: int)
+weight("a","1.5").
+score("b","2",L1,0).
+score("a","10",L1,0).
+`
+
+	tests := []struct {
+		msg       string
+		format    Format
+		relations []string
+		want      string
+	}{
+		{
+			msg:       "csv",
+			format:    FormatCSV,
+			relations: []string{"score"},
+			want: `player,points,loc,time
+a,10,L1,0
+b,2,L1,0
+`,
+		},
+		{
+			msg:    "tsv, every relation",
+			format: FormatTSV,
+			want: "player\tpoints\tloc\ttime\na\t10\tL1\t0\nb\t2\tL1\t0\n" +
+				"\nA0\tA1\na\t1.5\n",
+		},
+		{
+			msg:       "jsonl",
+			format:    FormatJSONL,
+			relations: []string{"score", "weight"},
+			want: `{"relation":"score","player":"a","points":10,"loc":"L1","time":0}
+{"relation":"score","player":"b","points":2,"loc":"L1","time":0}
+{"relation":"weight","A0":"a","A1":1.5}
+`,
+		},
+		{
+			msg:       "json",
+			format:    FormatJSON,
+			relations: []string{"weight"},
+			want: `[
+  {
+    "relation": "weight",
+    "columns": [
+      "A0",
+      "A1"
+    ],
+    "facts": [
+      {
+        "A0": "a",
+        "A1": 1.5
+      }
+    ]
+  }
+]
+`,
+		},
+		{
+			msg:       "table",
+			format:    FormatTable,
+			relations: []string{"score"},
+			want: `Idx player points Loc Time
+1.  a      10     L1  0
+2.  b      2      L1  0
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			p, err := ast.Parse(strings.NewReader(program))
+			if err != nil {
+				t.Errorf("unable to parse the program: %v", err)
+				return
+			}
+
+			r, err := NewRunner(p)
+			if err != nil {
+				t.Errorf("unable to initialize the runner: %v", err)
+				return
+			}
+
+			var buf bytes.Buffer
+			if err := r.WriteRelations(&buf, tt.format, tt.relations...); err != nil {
+				t.Errorf("unable to write the relations: %v", err)
+				return
+			}
+			if diff := cmp.Diff(buf.String(), tt.want); diff != "" {
+				t.Errorf("output diff (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
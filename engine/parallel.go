@@ -0,0 +1,209 @@
+package engine
+
+import (
+	"sync"
+
+	"golang.org/x/exp/slices"
+)
+
+// SetWorkers sets how many locations are evaluated concurrently during each timestep (1 by
+// default). Locations are only evaluated concurrently if no rule derives facts for another location
+// within a timestep, since each location then reaches its fixpoint independently. The facts derived
+// do not depend on the number of workers, but with more than one, the derivations of each timestep
+// are logged and streamed one location at a time (in order of location).
+func (r *Runner) SetWorkers(n int) {
+	if n < 1 {
+		n = 1
+	}
+	r.workers = n
+}
+
+// locationsIndependent reports whether every rule deriving facts within a timestep derives them at
+// the location whose facts it read.
+func (r *Runner) locationsIndependent() bool {
+	for _, stratum := range r.strata {
+		for _, rl := range stratum {
+			if rl.bodyLocVar == nil || rl.bodyLocVar != rl.headLocVar {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// evaluatedLocations returns the locations which evaluate rules at the given time, in order.
+func (r *Runner) evaluatedLocations(time int) []string {
+	var locs []string
+	for _, loc := range r.sortedLocations() {
+		if !r.down(loc, time) && (r.local == "" || loc == r.local) {
+			locs = append(locs, loc)
+		}
+	}
+	return locs
+}
+
+// locationEval evaluates a single location for a timestep without modifying the runner, so that
+// locations can be evaluated concurrently. Facts it derives are added to private copies of the
+// relations at the location, and are merged into the runner once every location is done.
+type locationEval struct {
+	r    *Runner
+	loc  string
+	time int
+
+	// The facts of each located relation at the location and time, including those derived since.
+	views map[*Relation]*Relation
+
+	// Every derivation for the current timestep, in the order it was derived, along with the fact
+	// it added (or nil if the fact already held).
+	delivered []deliveredFact
+	// The derivations of each rule in r.temporalRules, which are delivered when they are merged.
+	temporal [][]*derivation
+}
+
+type deliveredFact struct {
+	d *derivation
+	f *fact
+}
+
+// evaluateConcurrently evaluates the given locations with a pool of workers, and then merges what
+// each derived in order of location (see evaluate).
+func (r *Runner) evaluateConcurrently(locs []string, time int) []*derivation {
+	evals := make([]*locationEval, len(locs))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < r.workers && w < len(locs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				e := &locationEval{r: r, loc: locs[i], time: time, views: map[*Relation]*Relation{}}
+				e.run()
+				evals[i] = e
+			}
+		}()
+	}
+	for i := range locs {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	for _, e := range evals {
+		for _, df := range e.delivered {
+			r.logDerivation(df.d, time)
+			if df.f != nil {
+				df.d.rule.head.index(df.f)
+				r.derived(df.d.rule, df.f)
+			}
+		}
+	}
+
+	var msgs []*derivation
+	for i, rl := range r.temporalRules {
+		for _, e := range evals {
+			if rl.timeModel == TimeModelAsync {
+				msgs = append(msgs, e.temporal[i]...)
+				continue
+			}
+			for _, d := range e.temporal[i] {
+				if !r.intercept(d) {
+					r.deliver(d, time+1)
+				}
+			}
+		}
+	}
+	return msgs
+}
+
+// run mirrors evaluate for a single location.
+func (e *locationEval) run() {
+	for _, stratum := range e.r.strata {
+		delta := map[*Relation]*Relation{}
+		for _, rl := range stratum {
+			e.fire(rl, e.bodyViews(rl), delta)
+		}
+
+		for len(delta) > 0 {
+			prevDelta := delta
+			delta = map[*Relation]*Relation{}
+			for _, rl := range stratum {
+				if rl.hasAggregation {
+					continue
+				}
+
+				for i, rel := range rl.body {
+					d, ok := prevDelta[rel]
+					if !ok {
+						continue
+					}
+					body := e.bodyViews(rl)
+					body[i] = d
+					e.fire(rl, body, delta)
+				}
+			}
+		}
+	}
+
+	e.temporal = make([][]*derivation, len(e.r.temporalRules))
+	for i, rl := range e.r.temporalRules {
+		e.temporal[i] = e.derive(rl, e.bodyViews(rl))
+	}
+}
+
+// view returns the relation's facts at the location and time (read-only relations are shared).
+func (e *locationEval) view(rel *Relation) *Relation {
+	if rel.readOnly {
+		return rel
+	}
+	v, ok := e.views[rel]
+	if !ok {
+		v = newRelation(rel.id, rel.readOnly, rel.autoPersist, rel.numAttrs())
+		v.types = rel.types
+		for _, f := range rel.all(e.loc, e.time) {
+			v.index(f)
+		}
+		e.views[rel] = v
+	}
+	return v
+}
+
+func (e *locationEval) bodyViews(rl *Rule) []*Relation {
+	body := slices.Clone(rl.body)
+	for i, rel := range body {
+		body[i] = e.view(rel)
+	}
+	return body
+}
+
+func (e *locationEval) derive(rl *Rule, body []*Relation) []*derivation {
+	negated := slices.Clone(rl.negatedBody)
+	for i, rel := range negated {
+		negated[i] = e.view(rel)
+	}
+
+	ds := join(rl, body, negated, e.loc, e.time)
+	if rl.hasAggregation {
+		ds = aggregate(rl, ds)
+	}
+	return ds
+}
+
+// fire mirrors Runner.fire for rules deriving facts within the timestep, which are always for the
+// location being evaluated.
+func (e *locationEval) fire(rl *Rule, body []*Relation, delta map[*Relation]*Relation) {
+	for _, d := range e.derive(rl, body) {
+		f := e.view(rl.head).insert(d.data, d.to, e.time)
+		e.delivered = append(e.delivered, deliveredFact{d: d, f: f})
+		if f == nil {
+			continue
+		}
+
+		f.prov = &provenance{rule: d.rule, support: d.support}
+		dr, ok := delta[rl.head]
+		if !ok {
+			dr = newRelation(rl.head.id, rl.head.readOnly, false, rl.head.numAttrs())
+			delta[rl.head] = dr
+		}
+		dr.index(f)
+	}
+}
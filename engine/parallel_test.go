@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rithvikp/dedalus/ast"
+)
+
+func TestWorkers(t *testing.T) {
+	const graph = `
+hub("A").
+Edge("a","b",A,0).
+Edge("b","c",A,0).
+Edge("c","a",A,0).
+Edge("c","d",A,0).
+Edge("x","y",B,0).
+Edge("y","z",B,0).
+Edge("p","q",C,0).
+Edge("q","p",C,0).
+Edge("m","n",D,0).
+path(x,y,l,t) :- Edge(x,y,l,t)
+path(x,z,l,t) :- path(x,y,l,t), Edge(y,z,l,t)
+oneWay(x,y,l,t) :- path(x,y,l,t), not path(y,x,l,t)
+reach(x,count<y>,l,t) :- path(x,y,l,t)
+Edge(y,x,l,t') :- oneWay(x,y,l,t), succ(t,t')
+report(x,n,l',t') :- reach(x,n,l,t), hub(l'), choose((x,n),t')
+`
+
+	tests := []struct {
+		msg    string
+		source string
+		// Whether locations can be evaluated concurrently.
+		independent bool
+	}{
+		{
+			msg:         "independent locations",
+			source:      graph,
+			independent: true,
+		},
+		{
+			msg: "derivations for other locations within a timestep",
+			source: graph + `
+copy(x,y,l',t) :- path(x,y,l,t), hub(l')
+`,
+			independent: false,
+		},
+	}
+
+	for _, tt := range tests {
+		run := func(workers int) (string, string) {
+			p, err := ast.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Fatalf("%s: unable to parse the program: %v", tt.msg, err)
+			}
+			r, err := NewRunner(p)
+			if err != nil {
+				t.Fatalf("%s: unable to initialize the runner: %v", tt.msg, err)
+			}
+			var log bytes.Buffer
+			r.SetDerivationLog(&log)
+			r.SetNetworkModel(UniformDelay{Min: 1, Max: 3})
+			r.SetWorkers(workers)
+			if got := r.locationsIndependent(); got != tt.independent {
+				t.Fatalf("%s: expected locationsIndependent to be %t, but it is %t", tt.msg, tt.independent, got)
+			}
+			for i := 0; i < 6; i++ {
+				r.Step()
+			}
+
+			var rels bytes.Buffer
+			if err := r.WriteRelations(&rels, FormatJSONL); err != nil {
+				t.Fatalf("%s: unable to write the relations: %v", tt.msg, err)
+			}
+			// Facts derived by a rule at a location are logged in no particular order.
+			lines := strings.Split(log.String(), "\n")
+			sort.Strings(lines)
+			return rels.String(), strings.Join(lines, "\n")
+		}
+
+		serial, serialLog := run(1)
+		for _, workers := range []int{2, 3, 8} {
+			rels, log := run(workers)
+			if diff := cmp.Diff(serial, rels); diff != "" {
+				t.Errorf("%s: unexpected relations with %d workers (-serial +concurrent):\n%s", tt.msg, workers, diff)
+			}
+			if diff := cmp.Diff(serialLog, log); diff != "" {
+				t.Errorf("%s: unexpected derivations with %d workers (-serial +concurrent):\n%s", tt.msg, workers, diff)
+			}
+		}
+	}
+}
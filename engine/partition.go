@@ -0,0 +1,138 @@
+package engine
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// PartitionMode determines what happens to facts sent across a network partition.
+type PartitionMode int
+
+const (
+	// PartitionDrop drops every fact sent across the partition.
+	PartitionDrop PartitionMode = iota
+	// PartitionHold holds facts sent across the partition until it heals, at which point they are
+	// sent again.
+	PartitionHold
+)
+
+// Partition splits the locations into groups between timesteps Start (inclusive) and End
+// (exclusive). Facts derived by successor or async rules at a location in one group for a location
+// in another are dropped or held, depending on the mode. Locations which are not listed in any group
+// form an additional group of their own.
+type Partition struct {
+	Start int
+	// If positive, the partition heals at this timestep.
+	End    int
+	Groups [][]string
+	Mode   PartitionMode
+}
+
+func (p Partition) String() string {
+	groups := make([]string, len(p.Groups))
+	for i, g := range p.Groups {
+		groups[i] = strings.Join(g, ",")
+	}
+	s := fmt.Sprintf("%s from %d", strings.Join(groups, "|"), p.Start)
+	if p.End > 0 {
+		s += fmt.Sprintf(" to %d", p.End)
+	}
+	return s
+}
+
+func (p Partition) active(time int) bool {
+	return time >= p.Start && (p.End <= 0 || time < p.End)
+}
+
+// group returns the index of the group containing the location (len(p.Groups) if it is unlisted).
+func (p Partition) group(loc string) int {
+	for i, g := range p.Groups {
+		for _, l := range g {
+			if l == loc {
+				return i
+			}
+		}
+	}
+	return len(p.Groups)
+}
+
+func (p Partition) separates(from, to string, time int) bool {
+	return p.active(time) && p.group(from) != p.group(to)
+}
+
+type heldDerivation struct {
+	d         *derivation
+	partition *Partition
+}
+
+// AddPartition schedules a network partition, which must not start before the current timestep.
+// Adding a partition which is already scheduled (for example, by a restored checkpoint) does
+// nothing.
+func (r *Runner) AddPartition(p Partition) error {
+	for _, other := range r.partitions {
+		if reflect.DeepEqual(*other, p) {
+			return nil
+		}
+	}
+	if p.End > 0 && p.End <= p.Start {
+		return fmt.Errorf("invalid partition (%s): it must heal after it starts", p)
+	} else if p.Start < r.currentTimestamp {
+		// With per-location clocks, this is the earliest timestep any location has yet to execute.
+		return fmt.Errorf("invalid partition (%s): the runner is already at timestep %d", p, r.currentTimestamp)
+	}
+	if p.Mode == PartitionHold && p.End <= 0 {
+		return fmt.Errorf("invalid partition (%s): held facts are only sent when it heals, so it needs an end", p)
+	}
+
+	seen := map[string]bool{}
+	for _, g := range p.Groups {
+		for _, loc := range g {
+			if seen[loc] {
+				return fmt.Errorf("invalid partition (%s): %q is in more than one group", p, loc)
+			}
+			seen[loc] = true
+		}
+	}
+
+	r.partitions = append(r.partitions, &p)
+	return nil
+}
+
+// intercept reports whether a derivation sent at the current timestep crosses an active partition,
+// in which case it is dropped or held.
+func (r *Runner) intercept(d *derivation) bool {
+	for _, p := range r.partitions {
+		if !p.separates(d.from, d.to, r.currentTimestamp) {
+			continue
+		}
+
+		if p.Mode == PartitionHold {
+			r.held = append(r.held, heldDerivation{d: d, partition: p})
+		}
+		return true
+	}
+	return false
+}
+
+// healPartitions resends every derivation held by partitions which heal at the current timestep.
+func (r *Runner) healPartitions() {
+	var held []heldDerivation
+	var released []*derivation
+	for _, h := range r.held {
+		if h.partition.End == r.currentTimestamp && (r.local == "" || h.d.from == r.local) {
+			released = append(released, h.d)
+		} else {
+			held = append(held, h)
+		}
+	}
+	r.held = held
+
+	for _, d := range released {
+		if d.rule.timeModel == TimeModelAsync {
+			r.send(d)
+		} else if !r.intercept(d) {
+			r.deliver(d, r.currentTimestamp)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/rithvikp/dedalus/ast"
+)
+
+func TestPartitions(t *testing.T) {
+	// A pings B every timestep, with either a successor or an async rule.
+	const preface = `
+peer("B").
+Src("x",A,0).
+`
+	const successor = `ping(v,t,l',t') :- Src(v,l,t), peer(l'), succ(t,t')`
+	const async = `ping(v,t,l',t') :- Src(v,l,t), peer(l'), choose((v,t),t')`
+
+	tests := []struct {
+		msg       string
+		rule      string
+		partition Partition
+		facts     []*testFact
+	}{
+		{
+			msg:       "successor, drop",
+			rule:      successor,
+			partition: Partition{Start: 1, End: 3, Groups: [][]string{{"A"}, {"B"}}, Mode: PartitionDrop},
+			facts: []*testFact{
+				{[]string{"x", "0"}, "B", 1}, {[]string{"x", "3"}, "B", 4},
+			},
+		},
+		{
+			msg:       "successor, hold",
+			rule:      successor,
+			partition: Partition{Start: 1, End: 3, Groups: [][]string{{"A"}, {"B"}}, Mode: PartitionHold},
+			facts: []*testFact{
+				{[]string{"x", "0"}, "B", 1},
+				{[]string{"x", "1"}, "B", 3}, {[]string{"x", "2"}, "B", 3},
+				{[]string{"x", "3"}, "B", 4},
+			},
+		},
+		{
+			msg:       "async, drop",
+			rule:      async,
+			partition: Partition{Start: 1, End: 3, Groups: [][]string{{"A"}, {"B"}}, Mode: PartitionDrop},
+			facts: []*testFact{
+				{[]string{"x", "0"}, "B", 1}, {[]string{"x", "3"}, "B", 4},
+			},
+		},
+		{
+			msg:       "async, hold",
+			rule:      async,
+			partition: Partition{Start: 1, End: 3, Groups: [][]string{{"A"}, {"B"}}, Mode: PartitionHold},
+			facts: []*testFact{
+				{[]string{"x", "0"}, "B", 1},
+				{[]string{"x", "1"}, "B", 4}, {[]string{"x", "2"}, "B", 4}, {[]string{"x", "3"}, "B", 4},
+			},
+		},
+		{
+			msg:       "same group",
+			rule:      async,
+			partition: Partition{Start: 1, End: 3, Groups: [][]string{{"A", "B"}, {"C"}}},
+			facts: []*testFact{
+				{[]string{"x", "0"}, "B", 1}, {[]string{"x", "1"}, "B", 2},
+				{[]string{"x", "2"}, "B", 3}, {[]string{"x", "3"}, "B", 4},
+			},
+		},
+		{
+			msg:       "unlisted locations",
+			rule:      async,
+			partition: Partition{Start: 1, Groups: [][]string{{"A"}}},
+			facts: []*testFact{
+				{[]string{"x", "0"}, "B", 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			p, err := ast.Parse(strings.NewReader(preface + tt.rule))
+			if err != nil {
+				t.Errorf("unable to parse the program: %v", err)
+				return
+			}
+
+			r, err := NewRunner(p)
+			if err != nil {
+				t.Errorf("unable to initialize the runner: %v", err)
+				return
+			}
+			r.SetDerivationLog(nil)
+			r.SetNetworkModel(FixedDelay(1))
+			if err := r.AddPartition(tt.partition); err != nil {
+				t.Errorf("unable to add a partition: %v", err)
+				return
+			}
+
+			for i := 0; i < 4; i++ {
+				r.Step()
+			}
+
+			got := toTestFacts(r.relations["ping"].allAcrossSpaceTime())
+			if diff := cmp.Diff(got, tt.facts, cmp.AllowUnexported(testFact{}), cmpopts.SortSlices(lessFacts)); diff != "" {
+				t.Errorf("fact diff for relation ping (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestInvalidPartitions(t *testing.T) {
+	p, err := ast.Parse(strings.NewReader(`out(a,l,t) :- in(a,l,t)`))
+	if err != nil {
+		t.Fatalf("unable to parse the program: %v", err)
+	}
+	r, err := NewRunner(p)
+	if err != nil {
+		t.Fatalf("unable to initialize the runner: %v", err)
+	}
+
+	if err := r.AddPartition(Partition{Start: 3, End: 2, Groups: [][]string{{"A"}}}); err == nil {
+		t.Errorf("expected an error for a partition which heals before it starts")
+	}
+	if err := r.AddPartition(Partition{Start: 1, Groups: [][]string{{"A"}, {"A"}}}); err == nil {
+		t.Errorf("expected an error for a location in more than one group")
+	}
+	if err := r.AddPartition(Partition{Start: 1, Groups: [][]string{{"A"}}, Mode: PartitionHold}); err == nil {
+		t.Errorf("expected an error for a held partition which never heals")
+	}
+	if err := r.AddPartition(Partition{Start: 1, Groups: [][]string{{"A"}}, Mode: PartitionDrop}); err != nil {
+		t.Errorf("unexpected error adding a partition: %v", err)
+	}
+
+	r.SetDerivationLog(nil)
+	r.Step()
+	r.Step()
+	if err := r.AddPartition(Partition{Start: 1, End: 4, Groups: [][]string{{"B"}}}); err == nil {
+		t.Errorf("expected an error for a partition which starts before the current timestep")
+	}
+	if err := r.AddPartition(Partition{Start: 2, End: 4, Groups: [][]string{{"B"}}}); err != nil {
+		t.Errorf("unexpected error adding a partition: %v", err)
+	}
+}
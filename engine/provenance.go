@@ -0,0 +1,170 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// provenance records how a fact came to exist.
+type provenance struct {
+	// The rule which derived the fact and, for each binding of its body which produced it, the body
+	// facts (aligned with the rule's body). Aggregated facts have one binding per contributing
+	// fact.
+	rule    *Rule
+	support [][]*fact
+
+	// Set if the fact was carried over from an earlier timestep, either by automatic persistence or
+	// by being recovered when its location restarted.
+	persistedFrom *fact
+	recovered     bool
+
+	// Set if the fact was restored from a checkpoint, which does not record how it was derived.
+	restored bool
+	// The location which sent the fact, if it was received from another node.
+	receivedFrom string
+}
+
+// Derivation explains how a fact was derived, as a tree of the facts which support it.
+type Derivation struct {
+	Relation  string
+	Tuple     []string
+	Location  string
+	Timestamp int
+
+	// The rule which derived the fact, or nil if it was given by the program.
+	Rule *Rule
+	// If the fact was persisted from an earlier timestep, the timestamp at which it was originally
+	// derived (or given). Rule and Children then describe that original derivation.
+	PersistedFrom int
+	Persisted     bool
+	// Set if the fact was lost in a crash and recovered when its location restarted.
+	Recovered bool
+	// Set if the fact (or the fact it was persisted from) was restored from a checkpoint, in which
+	// case its derivation is unknown.
+	Restored bool
+	// The location which derived the fact, if it was received from another node (see Node). Its
+	// derivation is only known to that node.
+	ReceivedFrom string
+
+	// The derivations of the body facts of Rule. Aggregated facts include the body facts of every
+	// binding which contributed to the aggregate.
+	Children []*Derivation
+}
+
+func (d *Derivation) String() string {
+	var sb strings.Builder
+	d.write(&sb, 0)
+	return sb.String()
+}
+
+func (d *Derivation) write(sb *strings.Builder, depth int) {
+	sb.WriteString(strings.Repeat("  ", depth))
+	sb.WriteString(d.Relation + "(" + strings.Join(d.Tuple, ", ") + ")")
+	if d.Location != "" {
+		fmt.Fprintf(sb, " @ %s, %d", d.Location, d.Timestamp)
+	}
+	if d.Persisted {
+		fmt.Fprintf(sb, " persisted since %d", d.PersistedFrom)
+		if d.Recovered {
+			sb.WriteString(" (recovered after a crash)")
+		}
+	}
+	if d.Restored {
+		sb.WriteString(" restored from a checkpoint")
+	} else if d.ReceivedFrom != "" {
+		sb.WriteString(" received from " + d.ReceivedFrom)
+	} else if d.Rule == nil {
+		sb.WriteString(" given")
+	} else {
+		fmt.Fprintf(sb, " by rule %s (line %d)", d.Rule.id, d.Rule.pos.Line)
+	}
+	sb.WriteString("\n")
+
+	for _, c := range d.Children {
+		c.write(sb, depth+1)
+	}
+}
+
+// Why explains how the given fact was derived. The tuple's attributes are given in their textual
+// form, as in the program. The location and timestamp are ignored for read-only relations.
+func (r *Runner) Why(relation string, tuple []string, loc string, time int) (*Derivation, error) {
+	rel, ok := r.relations[relation]
+	if !ok {
+		return nil, fmt.Errorf("the relation %q does not exist", relation)
+	}
+	if len(tuple) != rel.numAttrs() {
+		return nil, fmt.Errorf("%q has %d attributes, but %d were given", relation, rel.numAttrs(), len(tuple))
+	}
+	data, err := rel.parseRow(tuple)
+	if err != nil {
+		return nil, err
+	}
+
+	f := rel.find(data, loc, time)
+	if f == nil {
+		return nil, fmt.Errorf("%s(%s) does not hold at %s at timestep %d", relation, strings.Join(tuple, ", "), loc, time)
+	}
+
+	return explain(rel, f, map[*fact]*Derivation{}), nil
+}
+
+// explain builds the derivation tree of a fact in the given relation. Facts supporting several
+// others are only explained once, so the resulting trees share subtrees.
+func explain(rel *Relation, f *fact, memo map[*fact]*Derivation) *Derivation {
+	if d, ok := memo[f]; ok {
+		return d
+	}
+
+	d := &Derivation{
+		Relation:  rel.id,
+		Tuple:     valueStrings(f.data),
+		Location:  f.location,
+		Timestamp: f.timestamp,
+	}
+	memo[f] = d
+
+	// Collapse chains of persisted facts into the fact they were originally derived from.
+	origin := f
+	for origin.prov != nil && origin.prov.persistedFrom != nil {
+		d.Persisted = true
+		d.Recovered = d.Recovered || origin.prov.recovered
+		origin = origin.prov.persistedFrom
+	}
+	if d.Persisted {
+		d.PersistedFrom = origin.timestamp
+	}
+	if origin.prov == nil {
+		return d
+	}
+	d.Restored = origin.prov.restored
+	d.ReceivedFrom = origin.prov.receivedFrom
+
+	d.Rule = origin.prov.rule
+	// Bindings are found in an arbitrary order, so sort them for stable explanations.
+	support := slices.Clone(origin.prov.support)
+	sort.SliceStable(support, func(i, j int) bool {
+		return bindingKey(support[i]) < bindingKey(support[j])
+	})
+	seen := map[*fact]bool{}
+	for _, binding := range support {
+		for i, sf := range binding {
+			if seen[sf] {
+				continue
+			}
+			seen[sf] = true
+			d.Children = append(d.Children, explain(d.Rule.body[i], sf, memo))
+		}
+	}
+	return d
+}
+
+func bindingKey(binding []*fact) string {
+	var key []string
+	for _, f := range binding {
+		key = append(key, fmt.Sprintf("%s@%s,%d", strings.Join(valueStrings(f.data), ","), f.location, f.timestamp))
+	}
+	return strings.Join(key, ";")
+}
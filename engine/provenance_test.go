@@ -0,0 +1,151 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rithvikp/dedalus/ast"
+)
+
+func TestWhy(t *testing.T) {
+	tests := []struct {
+		msg      string
+		program  string
+		steps    int
+		relation string
+		tuple    []string
+		loc      string
+		time     int
+		want     string
+		err      bool
+	}{
+		{
+			msg: "recursive rules",
+			program: `
+edge("a","b",L1,0).
+edge("b","c",L1,0).
+path(x,y,l,t) :- edge(x,y,l,t)
+path(x,z,l,t) :- edge(x,y,l,t), path(y,z,l,t)
+`,
+			relation: "path",
+			tuple:    []string{"a", "c"},
+			loc:      "L1",
+			want: `path(a, c) @ L1, 0 by rule 1 (line 5)
+  edge(a, b) @ L1, 0 given
+  path(b, c) @ L1, 0 by rule 0 (line 4)
+    edge(b, c) @ L1, 0 given
+`,
+		},
+		{
+			msg: "persistence",
+			program: `
+start("x",L1,0).
+Seen(v,l,t') :- start(v,l,t), succ(t,t')
+`,
+			steps:    3,
+			relation: "Seen",
+			tuple:    []string{"x"},
+			loc:      "L1",
+			time:     3,
+			want: `Seen(x) @ L1, 3 persisted since 1 by rule 0 (line 3)
+  start(x) @ L1, 0 given
+`,
+		},
+		{
+			msg: "aggregation and read-only relations",
+			program: `
+weight("a","1").
+weight("b","2").
+item("a",L1,0).
+item("b",L1,0).
+total(sum<w>,l,t) :- item(i,l,t), weight(i,w)
+`,
+			relation: "total",
+			tuple:    []string{"3"},
+			loc:      "L1",
+			want: `total(3) @ L1, 0 by rule 0 (line 6)
+  item(a) @ L1, 0 given
+  weight(a, 1) given
+  item(b) @ L1, 0 given
+  weight(b, 2) given
+`,
+		},
+		{
+			msg: "async rules",
+			program: `
+peer("L2").
+ping("x",L1,0).
+pong(v,l',t') :- ping(v,l,t), peer(l'), choose((v),t')
+`,
+			steps:    1,
+			relation: "pong",
+			tuple:    []string{"x"},
+			loc:      "L2",
+			time:     1,
+			want: `pong(x) @ L2, 1 by rule 0 (line 4)
+  ping(x) @ L1, 0 given
+  peer(L2) given
+`,
+		},
+		{
+			msg: "missing fact",
+			program: `
+edge("a","b",L1,0).
+`,
+			relation: "edge",
+			tuple:    []string{"b", "a"},
+			loc:      "L1",
+			err:      true,
+		},
+		{
+			msg: "missing relation",
+			program: `
+edge("a","b",L1,0).
+`,
+			relation: "path",
+			tuple:    []string{"a", "b"},
+			loc:      "L1",
+			err:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			p, err := ast.Parse(strings.NewReader(tt.program))
+			if err != nil {
+				t.Errorf("unable to parse the program: %v", err)
+				return
+			}
+
+			r, err := NewRunner(p)
+			if err != nil {
+				t.Errorf("unable to initialize the runner: %v", err)
+				return
+			}
+			r.SetDerivationLog(nil)
+			r.SetNetworkModel(FixedDelay(1))
+			for i := 0; i < tt.steps; i++ {
+				r.Step()
+			}
+			// Evaluate the current timestep without advancing past it.
+			r.evaluate()
+
+			d, err := r.Why(tt.relation, tt.tuple, tt.loc, tt.time)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected an error, but got the derivation:\n%s", d)
+				}
+				return
+			} else if err != nil {
+				t.Errorf("unable to explain the fact: %v", err)
+				return
+			}
+
+			if diff := cmp.Diff(d.String(), tt.want); diff != "" {
+				t.Errorf("derivation diff (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rithvikp/dedalus/ast"
+)
+
+// queryRelationName is the head of the rule built for a query. It cannot clash with a relation in
+// the program, since it is not a valid identifier.
+const queryRelationName = "_query"
+
+// QueryResult is the set of bindings which satisfy a query.
+type QueryResult struct {
+	// The variables of the query, followed by the location and time at which it was satisfied.
+	Columns []string
+	Rows    [][]string
+}
+
+// QueryBody evaluates a rule body, such as `ack(n,c,l,t), c > 2`, against the current state and
+// returns every binding of its variables which satisfies it. The body is evaluated at the given
+// location and timestep, or at every location or timestep if loc is empty or time is negative. The
+// runner's state is not modified.
+func (r *Runner) QueryBody(body string, loc string, time int) (*QueryResult, error) {
+	p, err := ast.Parse(strings.NewReader("query(l,t) :- " + body))
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Statements) != 1 || p.Statements[0].Rule == nil {
+		return nil, errors.New("the query must be a single rule body")
+	}
+	astRule := p.Statements[0].Rule
+
+	// The head of the query binds every variable in its positive atoms.
+	var head []ast.HeadTerm
+	var locVar, timeVar *ast.Variable
+	seen := map[string]bool{}
+	for _, term := range astRule.Body {
+		atom := term.Atom
+		if atom == nil || atom.Negated {
+			continue
+		}
+		if _, ok := lateHandleAtoms[atom.Name]; ok {
+			return nil, fmt.Errorf("%q cannot be used in a query", atom.Name)
+		}
+		rel, ok := r.relations[atom.Name]
+		if !ok {
+			return nil, fmt.Errorf("the relation %q does not exist", atom.Name)
+		}
+
+		terms := atom.Terms
+		if !rel.readOnly && len(terms) >= 2 {
+			if locVar == nil {
+				locVar, timeVar = terms[len(terms)-2].Var, terms[len(terms)-1].Var
+				if locVar == nil || timeVar == nil {
+					return nil, errors.New("the location and time of a query must be variables")
+				}
+			}
+			terms = terms[:len(terms)-2]
+		}
+		for _, t := range terms {
+			if t.Var == nil || t.Var.Name == "_" || seen[t.Var.Name] {
+				continue
+			}
+			seen[t.Var.Name] = true
+			head = append(head, ast.HeadTerm{Variable: *t.Var})
+		}
+	}
+	if locVar == nil {
+		return nil, errors.New("the query must read at least one located relation")
+	}
+	astRule.Head = ast.HeadAtom{
+		Name:  queryRelationName,
+		Terms: append(head, ast.HeadTerm{Variable: *locVar}, ast.HeadTerm{Variable: *timeVar}),
+	}
+
+	scratch := &State{relations: map[string]*Relation{}, locations: r.locations, detached: true}
+	for name, rel := range r.relations {
+		scratch.relations[name] = rel
+	}
+	if err := scratch.addRule(astRule, "query"); err != nil {
+		return nil, err
+	}
+	rl := scratch.rules[0]
+	for name := range scratch.relations {
+		if _, ok := r.relations[name]; !ok && name != queryRelationName {
+			return nil, fmt.Errorf("the relation %q does not exist", name)
+		}
+	}
+	if err := r.checkQuery(rl); err != nil {
+		return nil, err
+	}
+
+	locs := r.sortedLocations()
+	if loc != "" {
+		locs = []string{loc}
+	}
+	times := []int{time}
+	if time < 0 {
+		times = nil
+		for t := 0; t <= r.lastTimestamp(); t++ {
+			times = append(times, t)
+		}
+	}
+
+	res := &QueryResult{}
+	for _, ht := range head {
+		res.Columns = append(res.Columns, ht.Variable.Name)
+	}
+	res.Columns = append(res.Columns, "loc", "time")
+	// Rows are ordered by time, then location, then bindings.
+	for _, t := range times {
+		for _, l := range locs {
+			var rows [][]string
+			for _, d := range join(rl, rl.body, rl.negatedBody, l, t) {
+				rows = append(rows, append(valueStrings(d.data), l, fmt.Sprint(t)))
+			}
+			sort.Slice(rows, func(i, j int) bool {
+				return strings.Join(rows[i], "\x00") < strings.Join(rows[j], "\x00")
+			})
+			res.Rows = append(res.Rows, rows...)
+		}
+	}
+	return res, nil
+}
+
+// lastTimestamp returns the latest timestep with facts, or the current timestep if it is later.
+func (r *Runner) lastTimestamp() int {
+	last := r.currentTimestamp
+	for _, rel := range r.relations {
+		for lt := range rel.ltIndex {
+			if lt.timestamp > last {
+				last = lt.timestamp
+			}
+		}
+	}
+	return last
+}
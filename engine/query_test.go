@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rithvikp/dedalus/ast"
+)
+
+func TestQueryBody(t *testing.T) {
+	const program = `
+.decl ack(node: string, count: int)
+.decl peer(node: location) edb
+Acked(n,c,l,t) :- ack(n,c,l,t)
+ack("n1","1",A,0).
+ack("n2","3",A,0).
+ack("n3","5",B,1).
+peer("A").
+peer("B").
+`
+
+	tests := []struct {
+		name    string
+		body    string
+		loc     string
+		time    int
+		columns []string
+		rows    [][]string
+		wantErr bool
+	}{
+		{
+			name:    "LocationAndTime",
+			body:    `ack(n,c,l,t)`,
+			loc:     "A",
+			time:    0,
+			columns: []string{"n", "c", "loc", "time"},
+			rows:    [][]string{{"n1", "1", "A", "0"}, {"n2", "3", "A", "0"}},
+		},
+		{
+			name:    "Condition",
+			body:    `Acked(n,c,l,t), c > 2`,
+			loc:     "",
+			time:    -1,
+			columns: []string{"n", "c", "loc", "time"},
+			rows: [][]string{
+				{"n2", "3", "A", "0"},
+				{"n2", "3", "A", "1"},
+				{"n3", "5", "B", "1"},
+				{"n2", "3", "A", "2"},
+				{"n3", "5", "B", "2"},
+			},
+		},
+		{
+			name:    "Negation",
+			body:    `peer(p), Acked(n,c,l,t), not ack(n,c,l,t)`,
+			loc:     "A",
+			time:    1,
+			columns: []string{"p", "n", "c", "loc", "time"},
+			rows: [][]string{
+				{"A", "n1", "1", "A", "1"},
+				{"A", "n2", "3", "A", "1"},
+				{"B", "n1", "1", "A", "1"},
+				{"B", "n2", "3", "A", "1"},
+			},
+		},
+		{
+			name:    "UnknownRelation",
+			body:    `nack(n,l,t)`,
+			time:    -1,
+			wantErr: true,
+		},
+		{
+			name:    "UnknownNegatedRelation",
+			body:    `ack(n,c,l,t), not nack(n,l,t)`,
+			time:    -1,
+			wantErr: true,
+		},
+		{
+			name:    "Successor",
+			body:    `ack(n,c,l,t), succ(t,t')`,
+			time:    -1,
+			wantErr: true,
+		},
+		{
+			name:    "TypeMismatch",
+			body:    `ack(n,c,l,t), c > "x"`,
+			time:    -1,
+			wantErr: true,
+		},
+		{
+			name:    "NotLocated",
+			body:    `peer(p)`,
+			time:    -1,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ast.Parse(strings.NewReader(program))
+			if err != nil {
+				t.Errorf("unable to parse the program: %v", err)
+				return
+			}
+			r, err := NewRunner(p)
+			if err != nil {
+				t.Errorf("unable to initialize the runner: %v", err)
+				return
+			}
+			r.SetDerivationLog(nil)
+			for i := 0; i < 2; i++ {
+				r.Step()
+			}
+			numRules := len(r.rules)
+			numRelations := len(r.relations)
+
+			res, err := r.QueryBody(tt.body, tt.loc, tt.time)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, but the query returned %v", res.Rows)
+				}
+				return
+			} else if err != nil {
+				t.Errorf("unable to evaluate the query: %v", err)
+				return
+			}
+
+			if diff := cmp.Diff(tt.columns, res.Columns); diff != "" {
+				t.Errorf("unexpected columns (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.rows, res.Rows); diff != "" {
+				t.Errorf("unexpected rows (-want +got):\n%s", diff)
+			}
+			if len(r.rules) != numRules || len(r.relations) != numRelations || len(r.relations["ack"].bodyRules) != 1 {
+				t.Errorf("the query modified the state")
+			}
+		})
+	}
+}
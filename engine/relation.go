@@ -2,6 +2,9 @@ package engine
 
 import (
 	"fmt"
+	"math"
+	"strconv"
+	"sync"
 
 	"github.com/rithvikp/dedalus/analysis/fn"
 	"golang.org/x/exp/slices"
@@ -11,11 +14,31 @@ type Relation struct {
 	id          string
 	readOnly    bool
 	autoPersist bool // Pascal-Cased relations are automatically persisted
+	stratum     int
 
 	headRules []*Rule
 	bodyRules []*Rule
-	indexes   []map[string]map[locTime][]*fact
-	ltIndex   map[locTime]struct{}
+	indexes   []map[value]map[locTime][]*fact
+	// The number of facts at each location and time.
+	ltIndex map[locTime]int
+	// Every fact at each location and time, keyed by its tuple (see tupleKey).
+	tuples map[locTime]map[string]*fact
+
+	// Indexes over several attributes, keyed by the attributes they cover. They are created the
+	// first time a join looks facts up by those attributes, which may happen concurrently (see
+	// SetWorkers), so compositeMu guards the map.
+	compositeMu sync.RWMutex
+	composite   map[string]*compositeIndex
+
+	// The type of each attribute, as resolved by the type checker, and the types suggested by
+	// literal data (such as preloads) for attributes the program does not otherwise constrain.
+	types        []valueType
+	literalTypes []valueType
+
+	// Set if the relation was declared with a .decl statement.
+	declared      bool
+	attrNames     []string
+	declaredTypes []valueType
 }
 
 type Variable struct {
@@ -46,6 +69,10 @@ func (a Attribute) Relation() *Relation {
 }
 
 func (a Attribute) String() string {
+	if a.relation != nil && a.relation.attrNames != nil {
+		return fmt.Sprintf("%s.%s", a.relation.id, a.relation.attrNames[a.index])
+	}
+
 	id := "<nil>"
 	if a.relation != nil {
 		id = a.relation.id
@@ -63,9 +90,12 @@ func (a Attribute) LessThan(b Attribute) bool {
 }
 
 type fact struct {
-	data      []string
+	data      []value
 	location  string
 	timestamp int
+
+	// How the fact came to exist. Nil for facts given by the program (or inserted directly).
+	prov *provenance
 }
 
 type locTime struct {
@@ -102,23 +132,66 @@ func newRelation(id string, readOnly, autoPersist bool, indexCount int) *Relatio
 		id:          id,
 		readOnly:    readOnly,
 		autoPersist: autoPersist,
-		indexes:     make([]map[string]map[locTime][]*fact, indexCount),
-		ltIndex:     map[locTime]struct{}{},
+		indexes:     make([]map[value]map[locTime][]*fact, indexCount),
+		ltIndex:     map[locTime]int{},
+		tuples:      map[locTime]map[string]*fact{},
+		composite:   map[string]*compositeIndex{},
 	}
 
 	for i := range r.indexes {
-		r.indexes[i] = map[string]map[locTime][]*fact{}
+		r.indexes[i] = map[value]map[locTime][]*fact{}
 	}
 	return r
 }
 
+// addLiteralTypes records the types suggested by a row of literal data.
+func (r *Relation) addLiteralTypes(row []string) {
+	if r.literalTypes == nil {
+		r.literalTypes = make([]valueType, r.numAttrs())
+	}
+	for i, s := range row {
+		r.literalTypes[i] = unifyLiteralTypes(r.literalTypes[i], literalType(s))
+	}
+}
+
+// parseRow converts a row of literal data into values of the relation's attribute types.
+func (r *Relation) parseRow(row []string) ([]value, error) {
+	if len(row) != r.numAttrs() {
+		return nil, fmt.Errorf("%q has %d attributes, but %d values were provided", r.id, r.numAttrs(), len(row))
+	}
+
+	d := make([]value, len(row))
+	for i, s := range row {
+		v, err := parseValue(s, r.types[i])
+		if err != nil {
+			return nil, fmt.Errorf("attribute %d of %q: %v", i, r.id, err)
+		}
+		d[i] = v
+	}
+	return d, nil
+}
+
+// attrName returns the declared name of the given attribute, falling back to its position.
+func (r *Relation) attrName(i int) string {
+	if r.attrNames != nil {
+		return r.attrNames[i]
+	}
+	return fmt.Sprintf("A%d", i)
+}
+
 func (r *Relation) numAttrs() int {
 	return len(r.indexes)
 }
 
-func (r *Relation) push(d []string, loc string, time int) bool {
+func (r *Relation) push(d []value, loc string, time int) bool {
+	return r.insert(d, loc, time) != nil
+}
+
+// insert adds a fact to the relation, returning it (or nil if the relation already contains it).
+func (r *Relation) insert(d []value, loc string, time int) *fact {
+	d = r.coerce(d)
 	if r.contains(d, loc, time) {
-		return false
+		return nil
 	}
 
 	lt := locTime{}
@@ -131,52 +204,101 @@ func (r *Relation) push(d []string, loc string, time int) bool {
 		location:  lt.location,
 		timestamp: lt.timestamp,
 	}
+	r.index(f)
+	return f
+}
 
-	for i := range d {
-		if _, ok := r.indexes[i][d[i]]; !ok {
-			r.indexes[i][d[i]] = map[locTime][]*fact{}
+// coerce converts values to the types of the relation's attributes (see the package-level coerce),
+// since derived values (such as the result of arithmetic on ints) may be narrower. The data is only
+// copied if a value changes.
+func (r *Relation) coerce(d []value) []value {
+	var coerced []value
+	for i, t := range r.types {
+		if i >= len(d) {
+			break
+		}
+		if c := coerce(d[i], t); c != d[i] {
+			if coerced == nil {
+				coerced = slices.Clone(d)
+			}
+			coerced[i] = c
 		}
-		r.indexes[i][d[i]][lt] = append(r.indexes[i][d[i]][lt], f)
 	}
-	r.ltIndex[lt] = struct{}{}
-
-	return true
+	if coerced == nil {
+		return d
+	}
+	return coerced
 }
 
-func (r *Relation) contains(d []string, loc string, time int) bool {
-	if len(r.indexes) != len(d) {
-		return false
+// index adds an existing fact to the relation's indexes, without checking for duplicates.
+func (r *Relation) index(f *fact) {
+	lt := locTime{f.location, f.timestamp}
+	for i := range f.data {
+		if _, ok := r.indexes[i][f.data[i]]; !ok {
+			r.indexes[i][f.data[i]] = map[locTime][]*fact{}
+		}
+		r.indexes[i][f.data[i]][lt] = append(r.indexes[i][f.data[i]][lt], f)
 	}
+	r.ltIndex[lt]++
 
-	var factSet []*fact
-	var ok bool
-	if len(r.indexes) > 0 {
-		if factSet, ok = r.lookup(0, d[0], loc, time); !ok {
-			return false
-		}
-	} else {
-		if factSet, ok = r.lookup(-1, "", loc, time); !ok {
-			return false
-		}
+	if _, ok := r.tuples[lt]; !ok {
+		r.tuples[lt] = map[string]*fact{}
 	}
+	r.tuples[lt][tupleKey(f.data)] = f
+	for _, ci := range r.composite {
+		ci.index(f)
+	}
+}
 
-	for _, f := range factSet {
-		found := true
-		for i := range d {
-			if f.data[i] != d[i] {
-				found = false
-				break
-			}
+// removeIf removes every fact matching the predicate from the relation.
+func (r *Relation) removeIf(pred func(f *fact) bool) {
+	facts := r.allAcrossSpaceTime()
+	r.clear()
+	for _, f := range facts {
+		if !pred(f) {
+			r.index(f)
 		}
-		if found {
-			return true
+	}
+}
+
+// clear removes every fact from the relation.
+func (r *Relation) clear() {
+	for i := range r.indexes {
+		r.indexes[i] = map[value]map[locTime][]*fact{}
+	}
+	r.ltIndex = map[locTime]int{}
+	r.tuples = map[locTime]map[string]*fact{}
+	for _, ci := range r.composite {
+		ci.facts = map[locTime]map[string][]*fact{}
+	}
+}
+
+func (r *Relation) contains(d []value, loc string, time int) bool {
+	return r.find(d, loc, time) != nil
+}
+
+// find returns the fact with the given data at the given location and time, if there is one.
+func (r *Relation) find(d []value, loc string, time int) *fact {
+	if len(r.indexes) != len(d) {
+		return nil
+	}
+
+	if len(r.indexes) == 0 {
+		factSet, ok := r.lookup(-1, value{}, loc, time)
+		if !ok {
+			return nil
 		}
+		return factSet[0]
 	}
 
-	return false
+	lt := locTime{}
+	if !r.readOnly {
+		lt = locTime{loc, time}
+	}
+	return r.tuples[lt][tupleKey(r.coerce(d))]
 }
 
-func (r *Relation) lookup(attrIndex int, attrVal string, loc string, time int) ([]*fact, bool) {
+func (r *Relation) lookup(attrIndex int, attrVal value, loc string, time int) ([]*fact, bool) {
 	lt := locTime{}
 	if !r.readOnly {
 		lt = locTime{loc, time}
@@ -194,43 +316,128 @@ func (r *Relation) lookup(attrIndex int, attrVal string, loc string, time int) (
 	return matched, ok
 }
 
-// TODO: Replace this with an iterator-like variant for efficiency
-func (r *Relation) all(loc string, time int) []*fact {
-	var facts []*fact
-	if len(r.indexes) == 0 {
-		_, ok := r.lookup(-1, "", loc, time)
-		if ok {
-			facts = append(facts, &fact{location: loc, timestamp: time})
-		}
-	} else {
-		for attr := range r.indexes[0] {
-			// A match is guaranteed to be found
-			matched, _ := r.lookup(0, attr, loc, time)
-			facts = append(facts, matched...)
+// lookupAll returns the facts whose attributes (given in increasing order) have the given values,
+// through a composite index over those attributes.
+func (r *Relation) lookupAll(attrs []int, vals []value, loc string, time int) ([]*fact, bool) {
+	lt := locTime{}
+	if !r.readOnly {
+		lt = locTime{loc, time}
+	}
+	matched, ok := r.compositeIndex(attrs).facts[lt][tupleKey(vals)]
+	return matched, ok
+}
+
+// compositeIndex returns the index over the given attributes, creating it if necessary.
+func (r *Relation) compositeIndex(attrs []int) *compositeIndex {
+	key := fmt.Sprint(attrs)
+	r.compositeMu.RLock()
+	ci, ok := r.composite[key]
+	r.compositeMu.RUnlock()
+	if ok {
+		return ci
+	}
+
+	r.compositeMu.Lock()
+	defer r.compositeMu.Unlock()
+	if ci, ok := r.composite[key]; ok {
+		return ci
+	}
+	ci = &compositeIndex{attrs: attrs, facts: map[locTime]map[string][]*fact{}}
+	for _, f := range r.allAcrossSpaceTime() {
+		ci.index(f)
+	}
+	r.composite[key] = ci
+	return ci
+}
+
+// compositeIndex maps the values of several attributes (see tupleKey) to the facts which have
+// them, at each location and time.
+type compositeIndex struct {
+	attrs []int
+	facts map[locTime]map[string][]*fact
+}
+
+func (ci *compositeIndex) index(f *fact) {
+	lt := locTime{f.location, f.timestamp}
+	vals := make([]value, len(ci.attrs))
+	for i, a := range ci.attrs {
+		vals[i] = f.data[a]
+	}
+	key := tupleKey(vals)
+
+	if _, ok := ci.facts[lt]; !ok {
+		ci.facts[lt] = map[string][]*fact{}
+	}
+	ci.facts[lt][key] = append(ci.facts[lt][key], f)
+}
+
+// tupleKey encodes values as a string which is equal for two tuples exactly when their values are,
+// so tuples can be used as map keys.
+func tupleKey(vals []value) string {
+	var b []byte
+	for _, v := range vals {
+		b = append(b, byte(v.typ))
+		switch v.typ {
+		case typeInt:
+			b = strconv.AppendInt(b, int64(v.i), 10)
+		case typeFloat:
+			f := v.f
+			if f == 0 {
+				// Treat -0 as 0, as they are equal.
+				f = 0
+			}
+			b = strconv.AppendFloat(b, f, 'g', -1, 64)
+		case typeBool:
+			b = strconv.AppendBool(b, v.b)
+		default:
+			// Strings are prefixed with their length, so they cannot run into the next value.
+			b = strconv.AppendInt(b, int64(len(v.s)), 10)
+			b = append(b, ':')
+			b = append(b, v.s...)
 		}
+		b = append(b, 0)
 	}
+	return string(b)
+}
+
+// count returns the number of facts at the given location and time.
+func (r *Relation) count(loc string, time int) int {
+	if r.readOnly {
+		return r.ltIndex[locTime{}]
+	}
+	return r.ltIndex[locTime{loc, time}]
+}
 
+// all returns every fact at the given location and time.
+func (r *Relation) all(loc string, time int) []*fact {
+	lt := locTime{}
+	if !r.readOnly {
+		lt = locTime{loc, time}
+	}
+	facts := make([]*fact, 0, len(r.tuples[lt]))
+	for _, f := range r.tuples[lt] {
+		facts = append(facts, f)
+	}
 	return facts
 }
 
-// TODO: Replace this with an iterator-like variant for efficiency
-func (r *Relation) allAcrossSpaceTime() []*fact {
+// since returns every fact at or after the given time (and every fact in a read-only relation).
+func (r *Relation) since(time int) []*fact {
 	var facts []*fact
-	if len(r.indexes) == 0 {
-		for lt := range r.ltIndex {
-			facts = append(facts, &fact{location: lt.location, timestamp: lt.timestamp})
-		}
-	} else {
-		for _, factSuperSet := range r.indexes[0] {
-			for _, factSet := range factSuperSet {
-				facts = append(facts, factSet...)
+	for lt, tuples := range r.tuples {
+		if r.readOnly || lt.timestamp >= time {
+			for _, f := range tuples {
+				facts = append(facts, f)
 			}
 		}
 	}
-
 	return facts
 }
 
+func (r *Relation) allAcrossSpaceTime() []*fact {
+	return r.since(math.MinInt)
+}
+
 func (r *Relation) IsEDB() bool {
 	return r.readOnly // FIXME
 }
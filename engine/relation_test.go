@@ -1,5 +1,12 @@
 package engine
 
+import (
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
 //func TestCoreFDs(t *testing.T) {
 //tests := []struct {
 //msg   string
@@ -30,3 +37,90 @@ package engine
 //})
 //}
 //}
+
+func TestRelationIndexes(t *testing.T) {
+	rel := newRelation("r", false, false, 3)
+	insert := func(a, b int, c string, loc string, time int) {
+		t.Helper()
+		if rel.insert([]value{intValue(a), intValue(b), stringValue(c)}, loc, time) == nil {
+			t.Fatalf("unable to insert (%d, %d, %s) at %s, %d", a, b, c, loc, time)
+		}
+	}
+	insert(1, 2, "x", "A", 0)
+	insert(1, 2, "y", "A", 0)
+	insert(1, 3, "x", "A", 0)
+	insert(1, 2, "x", "B", 0)
+
+	// The composite index is created by this lookup, and must be kept up to date afterwards.
+	if got, _ := rel.lookupAll([]int{0, 1}, []value{intValue(1), intValue(2)}, "A", 0); len(got) != 2 {
+		t.Errorf("expected 2 facts with (1, 2) at A, but found %d", len(got))
+	}
+	insert(1, 2, "z", "A", 0)
+
+	tests := []struct {
+		msg   string
+		attrs []int
+		vals  []value
+		loc   string
+		want  []string
+	}{
+		{
+			msg:   "first two attributes",
+			attrs: []int{0, 1},
+			vals:  []value{intValue(1), intValue(2)},
+			loc:   "A",
+			want:  []string{"x", "y", "z"},
+		},
+		{
+			msg:   "first and last attributes",
+			attrs: []int{0, 2},
+			vals:  []value{intValue(1), stringValue("x")},
+			loc:   "A",
+			want:  []string{"x", "x"},
+		},
+		{
+			msg:   "other location",
+			attrs: []int{0, 1},
+			vals:  []value{intValue(1), intValue(2)},
+			loc:   "B",
+			want:  []string{"x"},
+		},
+		{
+			msg:   "values of another type",
+			attrs: []int{0, 1},
+			vals:  []value{stringValue("1"), intValue(2)},
+			loc:   "A",
+			want:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		facts, _ := rel.lookupAll(tt.attrs, tt.vals, tt.loc, 0)
+		var got []string
+		for _, f := range facts {
+			got = append(got, f.data[2].String())
+		}
+		sort.Strings(got)
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%s: unexpected facts (-want +got):\n%s", tt.msg, diff)
+		}
+	}
+
+	if !rel.contains([]value{intValue(1), intValue(2), stringValue("z")}, "A", 0) {
+		t.Errorf("expected (1, 2, z) to be at A")
+	}
+	if rel.contains([]value{intValue(1), intValue(2), stringValue("z")}, "B", 0) {
+		t.Errorf("expected (1, 2, z) not to be at B")
+	}
+	if rel.insert([]value{intValue(1), intValue(2), stringValue("z")}, "A", 0) != nil {
+		t.Errorf("expected inserting (1, 2, z) at A again to be a duplicate")
+	}
+
+	rel.removeIf(func(f *fact) bool { return f.location == "A" })
+	if got, ok := rel.lookupAll([]int{0, 1}, []value{intValue(1), intValue(2)}, "A", 0); ok || len(got) != 0 {
+		t.Errorf("expected no facts at A after removing them, but found %d", len(got))
+	}
+	if rel.contains([]value{intValue(1), intValue(2), stringValue("x")}, "A", 0) {
+		t.Errorf("expected (1, 2, x) to have been removed from A")
+	}
+}
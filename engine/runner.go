@@ -1,16 +1,12 @@
 package engine
 
 import (
-	"crypto/sha1"
 	"fmt"
-	"math/big"
-	"math/rand"
+	"io"
 	"os"
-	"sort"
-	"strings"
-	"text/tabwriter"
 
 	"github.com/rithvikp/dedalus/ast"
+	"golang.org/x/exp/slices"
 )
 
 // TODO: validate for safe negation, auto-persisted relations
@@ -18,6 +14,48 @@ import (
 type Runner struct {
 	*State
 	currentTimestamp int
+	// Identifies the program in checkpoints.
+	programHash string
+
+	// Every derived fact is logged here, if it is non-nil.
+	derivationLog io.Writer
+
+	network NetworkModel
+	seed    int64
+
+	// The number of locations evaluated concurrently (see SetWorkers).
+	workers int
+
+	faults []Fault
+	// The persisted facts of crashed locations, recovered when they restart.
+	recovered map[string]map[*Relation][]*fact
+
+	partitions []*Partition
+	held       []heldDerivation
+
+	// Set while only a single location is evaluated (see Node and SetScheduler), along with the
+	// function which ships facts derived for other locations.
+	local string
+	ship  func(*derivation)
+
+	// Set if every location has its own clock (see SetScheduler).
+	scheduler Scheduler
+	clocks    map[string]int
+	// The location and timestep executed by the last call to Step, with per-location clocks.
+	lastStep locTime
+
+	// The frame at the start of each of the last historyLimit timesteps executed by Step, for Rewind.
+	history      []*frame
+	historyLimit int
+
+	breakpoints    []*breakpoint
+	nextBreakpoint int
+	// The breakpoints hit during the last timestep.
+	hits []BreakpointHit
+
+	sinks     []*sink
+	sinkErr   error
+	callbacks []derivedCallback
 }
 
 func NewRunner(p *ast.Program) (*Runner, error) {
@@ -26,77 +64,115 @@ func NewRunner(p *ast.Program) (*Runner, error) {
 		return nil, err
 	}
 
-	return &Runner{State: s}, nil
+	r := &Runner{State: s, programHash: hashProgram(p), derivationLog: os.Stdout, network: defaultNetworkModel, workers: 1}
+	if err := r.addOutputs(p); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
 }
 
-func (r *Runner) Step() {
-	r.executed = true
+// SetDerivationLog sets where every derived fact is logged (stdout by default). A nil writer
+// disables logging.
+func (r *Runner) SetDerivationLog(w io.Writer) {
+	r.derivationLog = w
+}
 
-	var queue []*Rule
-	inQueue := map[string]struct{}{}
-	queue = append(queue, r.rules...)
+// CurrentTimestamp returns the timestep which the next call to Step will evaluate (or, if every
+// location has its own clock, the earliest timestep any location has yet to evaluate).
+func (r *Runner) CurrentTimestamp() int {
+	return r.currentTimestamp
+}
 
-	for _, rl := range queue {
-		inQueue[rl.id] = struct{}{}
-	}
+// derivation is a single head tuple derived by a rule, along with the location whose body facts
+// derived it and the location it is destined for.
+type derivation struct {
+	rule *Rule
+	data []value
+	from string
+	to   string
 
-	for len(queue) != 0 {
-		rl := queue[0]
-		delete(inQueue, rl.id)
-		queue = queue[1:]
+	// The body facts which derived the tuple (see provenance).
+	support [][]*fact
+}
 
-		time := r.currentTimestamp
+// Step evaluates a single timestep (at the location chosen by the scheduler, if one is set). Each
+// stratum is run to a fixpoint before the next one begins, so negated and aggregated relations are
+// complete by the time they are read. Rules deriving facts for future timesteps run last, followed
+// by automatic persistence.
+func (r *Runner) Step() {
+	if r.scheduler != nil {
+		r.stepScheduled()
+		return
+	}
 
-		var data [][]string
-		for loc := range r.locations {
-			ldata := join(rl, loc, time)
-			if rl.hasAggregation {
-				ldata = aggregate(rl, ldata)
-			}
+	r.record()
+	r.hits = nil
+	for _, msg := range r.evaluate() {
+		r.send(msg)
+	}
+	r.advance()
+	r.checkArrivals()
+}
 
-			data = append(data, ldata...)
+// evaluate runs every rule for the current timestep. Facts derived by async rules are returned
+// rather than delivered, so the caller can decide when they arrive.
+//
+// Strata are evaluated semi-naively: every rule is run once against the full relations, after which
+// a rule is only re-run with one of its body atoms restricted to the facts derived in the previous
+// round (the delta), until no new facts are derived.
+func (r *Runner) evaluate() []*derivation {
+	r.executed = true
+	time := r.currentTimestamp
+	if r.workers > 1 {
+		if locs := r.evaluatedLocations(time); len(locs) > 1 && r.locationsIndependent() {
+			return r.evaluateConcurrently(locs, time)
 		}
+	}
 
-		modified := false
-		for _, d := range data {
-			var nextTime int
-			switch rl.timeModel {
-			case TimeModelSame:
-				nextTime = time
-			case TimeModelSuccessor:
-				nextTime = time + 1
-			case TimeModelAsync:
-				combined := strings.Join(d, ";")
-				b := big.NewInt(0)
-				h := sha1.New()
-				h.Write([]byte(combined))
-
-				b.SetBytes(h.Sum(nil)[:7]) // h.Sum(nil) has a fixed size (sha1.Size).
-
-				randSrc := rand.NewSource(b.Int64())
-				nextTime = time + rand.New(randSrc).Intn(8)
-			}
+	for _, stratum := range r.strata {
+		delta := map[*Relation]*Relation{}
+		for _, rl := range stratum {
+			r.fire(rl, time, rl.body, nil, delta)
+		}
 
-			tuple := d[:len(d)-1]
-			nextLoc := d[len(d)-1]
-			// Keep track of new locations
-			r.locations[nextLoc] = struct{}{}
+		for len(delta) > 0 {
+			prevDelta := delta
+			delta = map[*Relation]*Relation{}
+			for _, rl := range stratum {
+				if rl.hasAggregation {
+					// Stratification guarantees that aggregated rules only read relations from lower
+					// strata, so they can never have a non-empty delta.
+					continue
+				}
 
-			fmt.Println(rl.head.id+":", tuple, nextLoc, nextTime)
-			if rl.head.push(tuple, nextLoc, nextTime) {
-				modified = true
+				for i, rel := range rl.body {
+					d, ok := prevDelta[rel]
+					if !ok {
+						continue
+					}
+					body := slices.Clone(rl.body)
+					body[i] = d
+					r.fire(rl, time, body, d, delta)
+				}
 			}
 		}
+	}
 
-		if modified {
-			for _, bodyRule := range rl.head.bodyRules {
-				if _, ok := inQueue[bodyRule.id]; !ok {
-					queue = append(queue)
-				}
-			}
+	var msgs []*derivation
+	for _, rl := range r.temporalRules {
+		if rl.timeModel == TimeModelAsync {
+			msgs = append(msgs, r.derive(rl, time, rl.body, nil)...)
+			continue
 		}
+		r.fire(rl, time, rl.body, nil, nil)
 	}
 
+	return msgs
+}
+
+// advance automatically persists PascalCased relations into the next timestep and moves to it.
+func (r *Runner) advance() {
 	// TODO: Optimize automatic persistence
 	for _, rel := range r.relations {
 		if !rel.autoPersist {
@@ -104,49 +180,111 @@ func (r *Runner) Step() {
 		}
 
 		for loc := range r.locations {
+			if r.local != "" && loc != r.local {
+				continue
+			}
 			for _, f := range rel.all(loc, r.currentTimestamp) {
-				rel.push(f.data, f.location, f.timestamp+1)
+				if pf := rel.insert(f.data, f.location, f.timestamp+1); pf != nil {
+					pf.prov = &provenance{persistedFrom: f}
+				}
 			}
 		}
 	}
 
 	r.currentTimestamp++
+	r.applyFaults()
+	r.healPartitions()
 }
 
-func (r *Runner) PrintRelation(name string) error {
-	rel, ok := r.relations[name]
-	if !ok {
-		return nil
-	}
+// derive evaluates the given rule at every location, reading the given body relations (which
+// correspond positionally to rl.body). If restrictTo is non-nil, only locations which have facts in
+// it at the current timestep are evaluated.
+func (r *Runner) derive(rl *Rule, time int, body []*Relation, restrictTo *Relation) []*derivation {
+	var derivations []*derivation
+	for _, loc := range r.sortedLocations() {
+		if r.down(loc, time) || r.local != "" && loc != r.local {
+			continue
+		}
+		if restrictTo != nil {
+			if _, ok := restrictTo.ltIndex[locTime{loc, time}]; !ok {
+				continue
+			}
+		}
 
-	tabw := new(tabwriter.Writer)
-	tabw.Init(os.Stdout, 4, 8, 1, ' ', 0)
+		ds := join(rl, body, rl.negatedBody, loc, time)
+		if rl.hasAggregation {
+			ds = aggregate(rl, ds)
+		}
+		derivations = append(derivations, ds...)
+	}
+	return derivations
+}
 
-	fmt.Fprint(tabw, "Idx\t")
-	for i := range rel.indexes {
-		fmt.Fprintf(tabw, "A%d\t", i)
+// fire derives facts with the given rule (see derive) and delivers them to its head immediately. Any
+// new fact derived for the current timestep is also added to the head's relation in delta (if delta
+// is non-nil).
+func (r *Runner) fire(rl *Rule, time int, body []*Relation, restrictTo *Relation, delta map[*Relation]*Relation) {
+	nextTime := time
+	if rl.timeModel == TimeModelSuccessor {
+		nextTime = time + 1
 	}
-	fmt.Fprintln(tabw, "Loc\tTime")
 
-	facts := rel.allAcrossSpaceTime()
-	sort.SliceStable(facts, func(i, j int) bool {
-		if facts[i].timestamp == facts[j].timestamp {
-			if facts[i].location <= facts[j].location {
-				return true
+	for _, d := range r.derive(rl, time, body, restrictTo) {
+		if rl.timeModel == TimeModelSuccessor && r.intercept(d) {
+			continue
+		}
+		if f := r.deliver(d, nextTime); f != nil && delta != nil && nextTime == time {
+			dr, ok := delta[rl.head]
+			if !ok {
+				dr = newRelation(rl.head.id, rl.head.readOnly, false, rl.head.numAttrs())
+				delta[rl.head] = dr
 			}
-			return false
-		} else if facts[i].timestamp < facts[j].timestamp {
-			return true
+			// The delta shares the fact, so provenance recorded while reading it refers to the head.
+			dr.index(f)
 		}
-		return false
-	})
+	}
+}
 
-	for i, f := range facts {
-		fmt.Fprintf(tabw, "%d.\t", i+1)
-		for _, val := range f.data {
-			fmt.Fprintf(tabw, "%s\t", val)
-		}
-		fmt.Fprintf(tabw, "%s\t%d\n", f.location, f.timestamp)
+// deliver pushes a derived fact into its head relation at the given time, returning it if it was
+// new (and nil otherwise). Facts delivered to a crashed location are dropped.
+func (r *Runner) deliver(d *derivation, time int) *fact {
+	if r.remote(d) {
+		return nil
+	}
+	return r.deliverAt(d, time)
+}
+
+// deliverAt pushes a derived fact into its head relation at the given time, even if it is destined
+// for a location other than the one being evaluated.
+func (r *Runner) deliverAt(d *derivation, time int) *fact {
+	// Keep track of new locations
+	r.locations[d.to] = struct{}{}
+	if r.down(d.to, time) {
+		return nil
+	}
+
+	r.logDerivation(d, time)
+	f := d.rule.head.insert(d.data, d.to, time)
+	if f != nil {
+		f.prov = &provenance{rule: d.rule, support: d.support}
+		r.derived(d.rule, f)
 	}
-	return tabw.Flush()
+	return f
+}
+
+func (r *Runner) logDerivation(d *derivation, time int) {
+	if r.derivationLog != nil {
+		fmt.Fprintln(r.derivationLog, d.rule.head.id+":", valueStrings(d.data), d.to, time)
+	}
+}
+
+// derived reports a new fact to sinks, callbacks and breakpoints.
+func (r *Runner) derived(rl *Rule, f *fact) {
+	r.emit(rl.head, f)
+	r.checkDerived(rl, f)
+}
+
+// PrintRelation writes every fact in the relation to stdout as a table.
+func (r *Runner) PrintRelation(name string) error {
+	return r.WriteRelations(os.Stdout, FormatTable, name)
 }
@@ -9,7 +9,22 @@ import (
 	"github.com/rithvikp/dedalus/ast"
 )
 
-func lessFacts(a, b *fact) bool {
+// testFact is a fact with its data rendered as strings, which keeps expected facts in tests terse.
+type testFact struct {
+	data      []string
+	location  string
+	timestamp int
+}
+
+func toTestFacts(facts []*fact) []*testFact {
+	tfs := make([]*testFact, len(facts))
+	for i, f := range facts {
+		tfs[i] = &testFact{data: valueStrings(f.data), location: f.location, timestamp: f.timestamp}
+	}
+	return tfs
+}
+
+func lessFacts(a, b *testFact) bool {
 	if a.timestamp < b.timestamp {
 		return true
 	} else if a.timestamp > b.timestamp {
@@ -38,7 +53,7 @@ func TestExecution(t *testing.T) {
 	tests := []struct {
 		msg    string
 		source string
-		facts  map[string][]*fact
+		facts  map[string][]*testFact
 	}{
 		{
 			msg: "join",
@@ -49,7 +64,7 @@ in1("1","2",L1,0).
 in1("3","2",L1,0).
 in2("2","3",L1,0).
 in2("1","2",L1,0).`,
-			facts: map[string][]*fact{
+			facts: map[string][]*testFact{
 				"out1": {{[]string{"1", "2", "3"}, "L1", 0}, {[]string{"3", "2", "3"}, "L1", 0}},
 				"out2": {{[]string{"1", "2", "3"}, "L1", 0}, {[]string{"3", "2", "3"}, "L1", 0}},
 			},
@@ -66,7 +81,7 @@ in3("3","4",L1,0).
 in3("4","5",L1,0).
 in4("1","2",L1,0).
 in4("3","4",L1,0).`,
-			facts: map[string][]*fact{
+			facts: map[string][]*testFact{
 				"out": {
 					{[]string{"1", "2", "3", "4", "2"}, "L1", 0},
 					{[]string{"1", "2", "3", "4", "4"}, "L1", 0},
@@ -84,7 +99,7 @@ in1("1","2",L1,0).
 in1("3","2",L1,0).
 in2("2","3",L1,0).
 in2("1","2",L1,0).`,
-			facts: map[string][]*fact{
+			facts: map[string][]*testFact{
 				"out1": {{[]string{"1", "2", "3"}, "L1", 1}, {[]string{"3", "2", "3"}, "L1", 1}},
 				"out2": {{[]string{"1", "2", "3"}, "L1", 1}, {[]string{"3", "2", "3"}, "L1", 1}},
 			},
@@ -95,7 +110,7 @@ in2("1","2",L1,0).`,
 out(a,l,t) :- in1(a,a,l,t)
 in1("a","a",L1,0).
 in1("a","b",L1,0).`,
-			facts: map[string][]*fact{
+			facts: map[string][]*testFact{
 				"out": {
 					{[]string{"a"}, "L1", 0},
 				},
@@ -112,7 +127,7 @@ in1("b","c",L1,0).
 in2("a","b",L1,0).
 in2("a","c",L1,0).
 in2("b","c",L1,0).`,
-			facts: map[string][]*fact{
+			facts: map[string][]*testFact{
 				"out1": {{[]string{"a", "b"}, "L1", 0}, {[]string{"a", "c"}, "L1", 0}},
 				"out2": {{[]string{"a", "b"}, "L1", 0}, {[]string{"a", "c"}, "L1", 0}},
 			},
@@ -127,7 +142,7 @@ in1("a","b",L1,0).
 in1("b","c",L1,0).
 in2("a","b",L1,0).
 in2("b","c",L1,0).`,
-			facts: map[string][]*fact{
+			facts: map[string][]*testFact{
 				"out1": {{[]string{"a", "a"}, "L1", 0}, {[]string{"a", "b"}, "L1", 0}},
 				"out2": {{[]string{"a", "a"}, "L1", 0}, {[]string{"a", "b"}, "L1", 0}},
 			},
@@ -141,7 +156,7 @@ in1("1","0",L1,0).
 in1("2","1",L1,0).
 in2("3","L1",L1,0).
 in2("4","L2",L1,0).`,
-			facts: map[string][]*fact{
+			facts: map[string][]*testFact{
 				"out1": {{[]string{"1", "3"}, "L1", 0}},
 				"out2": {{[]string{"1", "3"}, "L1", 0}},
 			},
@@ -155,7 +170,7 @@ in1("3","2","8","8",L1,0).
 in2("2","3","3",L1,0).
 in2("2","5","6",L1,0).
 in2("1","2","4",L1,0).`,
-			facts: map[string][]*fact{
+			facts: map[string][]*testFact{
 				"out": {
 					{[]string{"1", "2", "3"}, "L1", 0},
 					{[]string{"3", "2", "3"}, "L1", 0},
@@ -173,7 +188,7 @@ in1("1","2",L1,0).
 in1("3","2",L1,0).
 in2("2","3",L1,0).
 in2("1","2",L1,0).`,
-			facts: map[string][]*fact{
+			facts: map[string][]*testFact{
 				"out1": {{[]string{"3", "2", "3"}, "L1", 0}},
 				"out2": {{[]string{"3", "2", "3"}, "L1", 0}},
 			},
@@ -186,7 +201,7 @@ in1("1","2",L1,0).
 in1("3","2",L1,0).
 in2("2","3",L1,0).
 in2("1","2",L1,0).`,
-			facts: map[string][]*fact{
+			facts: map[string][]*testFact{
 				"out": {{[]string{"1", "2", "3"}, "L1", 0}},
 			},
 		},
@@ -198,7 +213,7 @@ in1("1","2",L1,0).
 in1("3","2",L1,0).
 in2("2","3",L1,0).
 in2("1","2",L1,0).`,
-			facts: map[string][]*fact{
+			facts: map[string][]*testFact{
 				"out": {{[]string{"2", "4", "3"}, "L1", 0}},
 			},
 		},
@@ -210,7 +225,7 @@ in1("1","2",L1,0).
 in1("3","2",L1,0).
 in2("2","3",L1,0).
 in2("1","2",L1,0).`,
-			facts: map[string][]*fact{
+			facts: map[string][]*testFact{
 				"out": {{[]string{"2", "2", "3"}, "L1", 0}},
 			},
 		},
@@ -223,7 +238,7 @@ in1("1","2",L1,0).
 in1("3","2",L1,0).
 in2("2","3",L1,0).
 in2("1","2",L1,0).`,
-			facts: map[string][]*fact{
+			facts: map[string][]*testFact{
 				"out1": {{[]string{"3", "2"}, "L1", 0}},
 				"out2": {{[]string{"3", "2"}, "L1", 0}},
 			},
@@ -239,7 +254,7 @@ in1("1","2",L1,0).
 in1("3","2",L1,0).
 in2("2","3",L1,0).
 in2("1","2",L1,0).`,
-			facts: map[string][]*fact{
+			facts: map[string][]*testFact{
 				"out1": {{[]string{"1", "2", "3"}, "L1", 0}, {[]string{"3", "2", "3"}, "L1", 0}},
 				"out2": {{[]string{"1", "2", "3"}, "L1", 0}, {[]string{"3", "2", "3"}, "L1", 0}},
 				"out3": {{[]string{"1", "2", "1"}, "L1", 0}, {[]string{"3", "2", "1"}, "L1", 0}},
@@ -254,7 +269,7 @@ in1("1","2",L1,0).
 in1("3","2",L1,0).
 in2("2","3",L1,0).
 in2("1","2",L1,0).`,
-			facts: map[string][]*fact{
+			facts: map[string][]*testFact{
 				"out": {{[]string{"1", "2", "1"}, "L1", 0}, {[]string{"3", "2", "1"}, "L1", 0}},
 			},
 		},
@@ -266,7 +281,7 @@ in1("1","2",L1,0).
 in1("3","2",L1,0).
 in2("2","3",L1,0).
 in2("1","2",L1,0).`,
-			facts: map[string][]*fact{
+			facts: map[string][]*testFact{
 				"out": {{[]string{"1", "2", "1", "3"}, "L1", 0}, {[]string{"3", "2", "1", "3"}, "L1", 0}},
 			},
 		},
@@ -281,7 +296,7 @@ in1("3","2").
 in2("2","3",L1,0).
 in2("1","2",L1,0).
 in3("3").`,
-			facts: map[string][]*fact{
+			facts: map[string][]*testFact{
 				"out1": {{[]string{"1", "2", "3"}, "L1", 0}, {[]string{"3", "2", "3"}, "L1", 0}},
 				"out2": {{[]string{"1", "2", "3"}, "L1", 0}, {[]string{"3", "2", "3"}, "L1", 0}},
 				"out3": {{[]string{"2", "3"}, "L1", 0}},
@@ -295,7 +310,7 @@ in1("1","2",L1,0).
 in1("3","2",L1,0).
 in2("2","3",L1,0).
 in2("1","2",L1,0).`,
-			facts: map[string][]*fact{
+			facts: map[string][]*testFact{
 				"Out": {
 					{[]string{"1", "2", "3"}, "L1", 0}, {[]string{"3", "2", "3"}, "L1", 0},
 					{[]string{"1", "2", "3"}, "L1", 1}, {[]string{"3", "2", "3"}, "L1", 1},
@@ -311,7 +326,7 @@ in1(L1,1).
 in2("2","3",L1,0).
 in2("1","2",L1,0).
 in2("1","5",L2,0).`,
-			facts: map[string][]*fact{
+			facts: map[string][]*testFact{
 				"out": {{[]string{"1", "2"}, "L1", 0}, {[]string{"2", "3"}, "L1", 0}},
 			},
 		},
@@ -321,7 +336,7 @@ in2("1","5",L2,0).`,
 out(a,l,t) :- in(a,3,l,t)
 in("1","2",L1,0).
 in("2","3",L1,0).`,
-			facts: map[string][]*fact{
+			facts: map[string][]*testFact{
 				"out": {{[]string{"2"}, "L1", 0}},
 			},
 		},
@@ -334,10 +349,92 @@ in1("3","3",L1,0).
 in2("2","3",L1,0).
 in2("2","4",L1,0).
 in2("1","2",L1,0).`,
-			facts: map[string][]*fact{
+			facts: map[string][]*testFact{
 				"out": {{[]string{"3", "3"}, "L1", 0}},
 			},
 		},
+		{
+			msg: "transitive closure",
+			source: `
+path(a,b,l,t) :- edge(a,b,l,t)
+path(a,c,l,t) :- edge(a,b,l,t), path(b,c,l,t)
+edge("1","2",L1,0).
+edge("2","3",L1,0).
+edge("3","4",L1,0).`,
+			facts: map[string][]*testFact{
+				"path": {
+					{[]string{"1", "2"}, "L1", 0}, {[]string{"1", "3"}, "L1", 0}, {[]string{"1", "4"}, "L1", 0},
+					{[]string{"2", "3"}, "L1", 0}, {[]string{"2", "4"}, "L1", 0},
+					{[]string{"3", "4"}, "L1", 0},
+				},
+			},
+		},
+		{
+			msg: "float arithmetic",
+			source: `
+out(a,b,l,t) :- in(a,l,t), b=a*2
+in("1.5",L1,0).
+in("2",L1,0).`,
+			facts: map[string][]*testFact{
+				"out": {{[]string{"1.5", "3"}, "L1", 0}, {[]string{"2", "4"}, "L1", 0}},
+			},
+		},
+		{
+			msg: "string ordering",
+			source: `
+out(a,b,l,t) :- in(a,b,l,t), a<b
+in("apple","banana",L1,0).
+in("cherry","banana",L1,0).`,
+			facts: map[string][]*testFact{
+				"out": {{[]string{"apple", "banana"}, "L1", 0}},
+			},
+		},
+		{
+			msg: "division by zero",
+			source: `
+out(a,b,c,l,t) :- in(a,b,l,t), c=a/b
+in("6","2",L1,0).
+in("6","0",L1,0).`,
+			facts: map[string][]*testFact{
+				"out": {{[]string{"6", "2", "3"}, "L1", 0}},
+			},
+		},
+		{
+			msg: "derived ints widen to floats",
+			source: `
+out(b,l,t) :- in(a,l,t), b=1
+out(a,l,t) :- in(a,l,t)
+in("1.0",L1,0).
+in("2.5",L1,0).`,
+			facts: map[string][]*testFact{
+				"out": {{[]string{"1"}, "L1", 0}, {[]string{"2.5"}, "L1", 0}},
+			},
+		},
+		{
+			msg: "stratified negation",
+			source: `
+out(a,l,t) :- in1(a,l,t), not mid(a,l,t)
+mid(a,l,t) :- in2(a,l,t)
+in1("1",L1,0).
+in1("2",L1,0).
+in2("2",L1,0).`,
+			facts: map[string][]*testFact{
+				"out": {{[]string{"1"}, "L1", 0}},
+			},
+		},
+		{
+			msg: "stratified aggregation",
+			source: `
+out(count<a>,l,t) :- mid(a,l,t)
+mid(a,l,t) :- in1(a,l,t)
+mid(a,l,t) :- in2(a,l,t)
+in1("1",L1,0).
+in1("2",L1,0).
+in2("3",L1,0).`,
+			facts: map[string][]*testFact{
+				"out": {{[]string{"3"}, "L1", 0}},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -362,12 +459,134 @@ in2("1","2",L1,0).`,
 					t.Errorf("the relation %q was not found in the engine state", rel)
 					continue
 				}
-				got := r.relations[rel].allAcrossSpaceTime()
+				got := toTestFacts(r.relations[rel].allAcrossSpaceTime())
 
-				if diff := cmp.Diff(got, want, cmp.AllowUnexported(fact{}), cmpopts.SortSlices(lessFacts)); diff != "" {
+				if diff := cmp.Diff(got, want, cmp.AllowUnexported(testFact{}), cmpopts.SortSlices(lessFacts)); diff != "" {
 					t.Errorf("fact diff for relation %q (-got, +want):\n%s", rel, diff)
 				}
 			}
 		})
 	}
 }
+
+func TestStratification(t *testing.T) {
+	tests := []struct {
+		msg          string
+		source       string
+		stratifiable bool
+	}{
+		{
+			msg: "negation through a temporal rule",
+			source: `
+p(a,l,t') :- q(a,l,t), not p(a,l,t), succ(t,t')
+q(a,l,t) :- p(a,l,t)`,
+			stratifiable: true,
+		},
+		{
+			msg: "negation cycle",
+			source: `
+p(a,l,t) :- q(a,l,t), not r(a,l,t)
+r(a,l,t) :- p(a,l,t)`,
+			stratifiable: false,
+		},
+		{
+			msg: "aggregation cycle",
+			source: `
+p(count<a>,l,t) :- q(a,l,t)
+q(a,l,t) :- p(a,l,t)`,
+			stratifiable: false,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			p, err := ast.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Errorf("unable to parse the program: %v", err)
+				return
+			}
+
+			_, err = New(p)
+			if tt.stratifiable && err != nil {
+				t.Errorf("unexpected error initializing the engine state: %v", err)
+			} else if !tt.stratifiable {
+				if _, ok := err.(*SemanticError); !ok {
+					t.Errorf("expected a semantic error for an unstratifiable program, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestAddRawRule(t *testing.T) {
+	p, err := ast.Parse(strings.NewReader(`
+p(a,l,t) :- q(a,l,t)
+q("1",L1,0).`))
+	if err != nil {
+		t.Fatalf("unable to parse the program: %v", err)
+	}
+	s, err := New(p)
+	if err != nil {
+		t.Fatalf("unable to initialize the engine state: %v", err)
+	}
+
+	for _, rule := range []string{
+		// Unstratifiable
+		`q(a,l,t) :- s(a,l,t), not p(a,l,t)
+`,
+		// Ill-typed
+		`r(b,l,t) :- q(a,l,t), s(a,l,t), b=a+l
+`,
+	} {
+		if err := s.AddRawRule(rule); err == nil {
+			t.Errorf("expected adding %q to fail", rule)
+		}
+	}
+	if len(s.rules) != 1 || len(s.Strata()) != 1 {
+		t.Errorf("rejected rules were not rolled back: %d rules in %d strata", len(s.rules), len(s.Strata()))
+	}
+	for _, id := range []string{"s", "r"} {
+		if _, ok := s.relations[id]; ok {
+			t.Errorf("the relation %q, only used by rejected rules, was not removed", id)
+		}
+	}
+	if len(s.relations["q"].bodyRules) != 1 || len(s.relations["p"].headRules) != 1 {
+		t.Errorf("rejected rules are still registered with their relations")
+	}
+
+	if err := s.AddRawRule("r(a,l,t) :- p(a,l,t), not q(a,l,t)\n"); err != nil {
+		t.Errorf("unable to add a rule after rejected ones: %v", err)
+	}
+}
+
+func TestAggregationDeterministic(t *testing.T) {
+	// Float sums depend on the order values are added in: 1e16 + 1 rounds back to 1e16.
+	const program = `
+in("a","1e16",L1,0).
+in("b","1.0",L1,0).
+in("c","-1e16",L1,0).
+out(sum<w>,l,t) :- in(k,w,l,t)
+`
+
+	var first []*testFact
+	for i := 0; i < 20; i++ {
+		p, err := ast.Parse(strings.NewReader(program))
+		if err != nil {
+			t.Fatalf("unable to parse the program: %v", err)
+		}
+		r, err := NewRunner(p)
+		if err != nil {
+			t.Fatalf("unable to initialize the runner: %v", err)
+		}
+		r.SetDerivationLog(nil)
+		r.Step()
+
+		got := toTestFacts(r.relations["out"].allAcrossSpaceTime())
+		if i == 0 {
+			first = got
+		} else if diff := cmp.Diff(got, first, cmp.AllowUnexported(testFact{})); diff != "" {
+			t.Fatalf("run %d differs from the first (-got, +want):\n%s", i, diff)
+		}
+	}
+}
@@ -42,6 +42,9 @@ var lateHandleAtoms = map[string]struct{}{
 	chooseRelationName:    {},
 }
 
+// reservedAttrNames are the names of columns which output and fact files add to every relation.
+var reservedAttrNames = map[string]bool{"loc": true, "time": true, "relation": true}
+
 type headTerm struct {
 	agg *aggregator // Optional
 	v   *Variable
@@ -49,6 +52,7 @@ type headTerm struct {
 
 type Rule struct {
 	id          string
+	pos         lexer.Position
 	head        *Relation
 	body        []*Relation
 	negatedBody []*Relation
@@ -76,9 +80,17 @@ type State struct {
 	relations map[string]*Relation
 
 	rules []*Rule
+	// Rules deriving facts within a timestep, grouped by stratum, and rules deriving facts for
+	// future timesteps (which run once every stratum has reached a fixpoint).
+	strata        [][]*Rule
+	temporalRules []*Rule
 
 	locations map[string]struct{}
 	executed  bool
+
+	// Rules added to a detached state are not registered with the relations they use, so a copy of
+	// a state can check a rule without affecting the original (see QueryBody).
+	detached bool
 }
 
 func (s *State) Rules() []*Rule {
@@ -219,6 +231,32 @@ func New(p *ast.Program) (*State, error) {
 		locations: map[string]struct{}{},
 	}
 
+	// Preloads are only pushed once every rule has been added, since the types of their attributes
+	// depend on how the relations are used.
+	type preload struct {
+		rel *Relation
+		row []string
+		ast *ast.Preload
+	}
+	var preloads []preload
+
+	// Facts loaded from files are pushed at the same time as preloads.
+	type input struct {
+		rel   *Relation
+		facts []fileFact
+		ast   *ast.Input
+	}
+	var inputs []input
+
+	// Declarations apply to every use of a relation, regardless of where they appear.
+	for _, astStatement := range p.Statements {
+		if astStatement.Decl != nil {
+			if err := state.addDecl(astStatement.Decl); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	var astRules []*ast.Rule
 	for _, astStatement := range p.Statements {
 		if astStatement.Rule != nil {
@@ -247,22 +285,117 @@ func New(p *ast.Program) (*State, error) {
 				return nil, newSemanticError("preload has a different number of attributes than the relation", astPreload.Pos)
 			}
 
-			if astPreload.Loc != nil && astPreload.Time != nil {
-				rel.push(row, *astPreload.Loc, *astPreload.Time)
-				state.locations[*astPreload.Loc] = struct{}{}
-			} else {
-				rel.push(row, "", 0)
+			rel.addLiteralTypes(row)
+			preloads = append(preloads, preload{rel: rel, row: row, ast: astPreload})
+		} else if astStatement.Input != nil {
+			astInput := astStatement.Input
+			t, err := readFactFile(astInput.File(), astInput.Name)
+			if err != nil {
+				return nil, newSemanticError(err.Error(), astInput.Pos)
+			}
+
+			numAttrs, located := t.shape()
+			if located {
+				numAttrs += 2
 			}
+			rel, err := state.addRel(astInput.Name, numAttrs, astInput.Pos, false, !located, nil)
+			if err != nil {
+				return nil, err
+			}
+
+			facts, err := t.facts(rel)
+			if err != nil {
+				return nil, newSemanticError(err.Error(), astInput.Pos)
+			}
+			for _, f := range facts {
+				rel.addLiteralTypes(f.row)
+			}
+			inputs = append(inputs, input{rel: rel, facts: facts, ast: astInput})
 		}
 	}
 
 	for i, astRule := range astRules {
-		state.addRule(astRule, strconv.Itoa(i))
+		if err := state.addRule(astRule, strconv.Itoa(i)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := state.stratify(); err != nil {
+		return nil, err
+	}
+	if err := state.typeCheck(); err != nil {
+		return nil, err
+	}
+
+	for _, pl := range preloads {
+		row, err := pl.rel.parseRow(pl.row)
+		if err != nil {
+			return nil, newSemanticError(err.Error(), pl.ast.Pos)
+		}
+
+		if pl.ast.Loc != nil && pl.ast.Time != nil {
+			pl.rel.push(row, *pl.ast.Loc, *pl.ast.Time)
+			state.locations[*pl.ast.Loc] = struct{}{}
+		} else {
+			pl.rel.push(row, "", 0)
+		}
+	}
+
+	for _, in := range inputs {
+		if err := state.pushFileFacts(in.rel, in.ast.File(), in.facts); err != nil {
+			return nil, newSemanticError(err.Error(), in.ast.Pos)
+		}
 	}
 
 	return &state, nil
 }
 
+func (s *State) addDecl(decl *ast.Decl) error {
+	if _, ok := s.relations[decl.Name]; ok {
+		return newSemanticError(fmt.Sprintf("%q is declared more than once", decl.Name), decl.Pos)
+	}
+
+	var edb, persisted, located bool
+	for _, q := range decl.Qualifiers {
+		switch q {
+		case "edb":
+			edb = true
+		case "persisted":
+			persisted = true
+		case "located":
+			located = true
+		}
+	}
+	if edb && located {
+		return newSemanticError(fmt.Sprintf("%q cannot be both an edb and located: edb relations are replicated to every location", decl.Name), decl.Pos)
+	} else if edb && persisted {
+		return newSemanticError(fmt.Sprintf("%q cannot be both an edb and persisted: edb relations do not change over time", decl.Name), decl.Pos)
+	}
+
+	rel := newRelation(decl.Name, edb, persisted, len(decl.Attrs))
+	rel.declared = true
+	rel.attrNames = make([]string, len(decl.Attrs))
+	rel.declaredTypes = make([]valueType, len(decl.Attrs))
+
+	seen := map[string]bool{}
+	for i, a := range decl.Attrs {
+		t, ok := parseValueType(a.Type)
+		if !ok {
+			return newSemanticError(fmt.Sprintf("unknown type %q", a.Type), a.Pos)
+		} else if seen[a.Name] {
+			return newSemanticError(fmt.Sprintf("%q has more than one attribute named %q", decl.Name, a.Name), a.Pos)
+		} else if reservedAttrNames[a.Name] {
+			return newSemanticError(fmt.Sprintf("%q cannot be used as an attribute name, since output and fact files use it for their own column", a.Name), a.Pos)
+		}
+		seen[a.Name] = true
+		rel.attrNames[i] = a.Name
+		rel.declaredTypes[i] = t
+	}
+
+	s.relations[decl.Name] = rel
+	return nil
+}
+
 // The rule argument is allowed to be nil if the relation addition is happening for a preload
 func (s *State) addRel(id string, numVars int, pos lexer.Position, head, readOnly bool, rl *Rule) (*Relation, error) {
 	var ok bool
@@ -284,12 +417,19 @@ func (s *State) addRel(id string, numVars int, pos lexer.Position, head, readOnl
 		if rel.readOnly && head {
 			return nil, newSemanticError(fmt.Sprintf("%q, a read-only relation cannot appear in the head of any rule", id), pos)
 		}
+		if rel.declared && (!rel.readOnly && rel.numAttrs() != numVars-2 || rel.readOnly && rel.numAttrs() != numVars) {
+			want := fmt.Sprintf("%d attributes", rel.numAttrs())
+			if !rel.readOnly {
+				want += " (plus location and time)"
+			}
+			return nil, newSemanticError(fmt.Sprintf("%q is declared with %s, but is used with %d terms", id, want, numVars), pos)
+		}
 		if !rel.readOnly && rel.numAttrs() != numVars-2 || rel.readOnly && rel.numAttrs() != numVars {
 			return nil, newSemanticError(fmt.Sprintf("the number of attributes must be constant for any given relation, but %q had %d attributes initially and has %d attributes now", rel.id, rel.numAttrs(), numVars), pos)
 		}
 	}
 
-	if rl != nil {
+	if rl != nil && !s.detached {
 		if head {
 			rel.headRules = append(rel.headRules, rl)
 		} else {
@@ -314,22 +454,77 @@ func (s *State) AddRawRule(rawRule string) error {
 		return errors.New("the provided raw string was not actually a single rule")
 	}
 
-	return s.addRule(p.Statements[0].Rule, strconv.Itoa(len(s.rules)))
+	// A rejected rule must not leave any trace, so that the state can still be used.
+	saved := s.saveRules()
+	err = s.addRule(p.Statements[0].Rule, strconv.Itoa(len(s.rules)))
+	if err == nil {
+		err = s.stratify()
+	}
+	if err == nil {
+		err = s.typeCheck()
+	}
+	if err != nil {
+		s.restoreRules(saved)
+	}
+	return err
+}
+
+// ruleState is the part of a State which adding a rule changes.
+type ruleState struct {
+	rules         []*Rule
+	strata        [][]*Rule
+	temporalRules []*Rule
+	relations     map[*Relation]relationRules
+}
+
+type relationRules struct {
+	headRules []*Rule
+	bodyRules []*Rule
+	stratum   int
+	types     []valueType
+}
+
+func (s *State) saveRules() *ruleState {
+	rs := &ruleState{
+		rules:         s.rules,
+		strata:        s.strata,
+		temporalRules: s.temporalRules,
+		relations:     map[*Relation]relationRules{},
+	}
+	for _, rel := range s.relations {
+		rs.relations[rel] = relationRules{headRules: rel.headRules, bodyRules: rel.bodyRules, stratum: rel.stratum, types: rel.types}
+	}
+	return rs
+}
+
+// restoreRules undoes every rule added since the state was saved, along with the relations they
+// introduced.
+func (s *State) restoreRules(rs *ruleState) {
+	s.rules, s.strata, s.temporalRules = rs.rules, rs.strata, rs.temporalRules
+	for id, rel := range s.relations {
+		saved, ok := rs.relations[rel]
+		if !ok {
+			delete(s.relations, id)
+			continue
+		}
+		rel.headRules, rel.bodyRules, rel.stratum, rel.types = saved.headRules, saved.bodyRules, saved.stratum, saved.types
+	}
 }
 
 func (s *State) addRule(astRule *ast.Rule, id string) error {
+	astHeadVars := astRule.Head.Terms
+	if len(astHeadVars) < 2 {
+		return newSemanticError(fmt.Sprintf("%q is not a replicated read-only relation so must have time and location attributes", astRule.Head.Name), astRule.Head.Pos)
+	}
+
 	rl := &Rule{
 		id:             id,
+		pos:            astRule.Pos,
 		headVarMapping: make([]headTerm, len(astRule.Head.Terms)-2),
 	}
 	vars := map[string]*Variable{}
 	rl.vars = map[string][]*Variable{}
 
-	astHeadVars := astRule.Head.Terms
-	if len(astHeadVars) < 2 {
-		return newSemanticError(fmt.Sprintf("%q is not a replicated read-only relation so must have time and location attributes", astRule.Head.Name), astRule.Head.Pos)
-	}
-
 	var err error
 	rl.head, err = s.addRel(astRule.Head.Name, len(astRule.Head.Terms), astRule.Pos, true, false, rl)
 	if err != nil {
@@ -556,17 +751,16 @@ func (s *State) addRule(astRule *ast.Rule, id string) error {
 			e = v
 
 			if astE.Expr != nil {
-				v, err = parseFirstTerm(astE)
-				if err != nil {
-					return nil, err
-				}
-
 				var bo *binOp
 				for astE.Expr != nil {
 					if bo == nil {
 						bo = &binOp{}
 						e = bo
 					} else {
+						v, err = parseFirstTerm(astE)
+						if err != nil {
+							return nil, err
+						}
 						bo2 := &binOp{}
 						bo.e2 = bo2
 						bo = bo2
@@ -600,12 +794,12 @@ func (s *State) addRule(astRule *ast.Rule, id string) error {
 			}
 			rl.assignments = append(rl.assignments, assignment{v: e1.(*Variable), e: e2})
 		} else {
-			rl.conditions = append(rl.conditions, condition{e1: e1, e2: e2, op: astCond.Operand})
+			rl.conditions = append(rl.conditions, newCondition(e1, e2, astCond.Operand))
 		}
 	}
 
 	for _, a := range constAssignments {
-		rl.conditions = append(rl.conditions, condition{e1: vars[a.Name], e2: number(a.Val), op: "="})
+		rl.conditions = append(rl.conditions, newCondition(vars[a.Name], number(a.Val), "="))
 	}
 
 	for _, astAtom := range lateAtoms {
@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/rithvikp/dedalus/ast"
+)
+
+// sink streams the facts derived for a relation to a writer as they are derived.
+type sink struct {
+	rel    *Relation
+	format Format
+	csv    *csv.Writer
+	w      io.Writer
+	// Set for sinks which own their file.
+	file *os.File
+}
+
+func (s *sink) write(f *fact) error {
+	switch s.format {
+	case FormatCSV, FormatTSV:
+		if err := s.csv.Write(s.rel.row(f)); err != nil {
+			return err
+		}
+		s.csv.Flush()
+		return s.csv.Error()
+	default:
+		b, err := jsonObject(append([]string{"relation"}, s.rel.columns()...), append([]any{s.rel.id}, s.rel.jsonValues(f)...))
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(s.w, "%s\n", b)
+		return err
+	}
+}
+
+// AddSink streams every fact newly derived for the relation to w, in the CSV, TSV or JSON Lines
+// format, as soon as it is derived. CSV and TSV sinks start with a header row.
+func (r *Runner) AddSink(relation string, w io.Writer, format Format) error {
+	rel, ok := r.relations[relation]
+	if !ok {
+		return fmt.Errorf("the relation %q does not exist", relation)
+	}
+
+	s := &sink{rel: rel, format: format, w: w}
+	switch format {
+	case FormatCSV, FormatTSV:
+		s.csv = csv.NewWriter(w)
+		if format == FormatTSV {
+			s.csv.Comma = '\t'
+		}
+		if err := s.csv.Write(rel.columns()); err != nil {
+			return err
+		}
+		s.csv.Flush()
+		if err := s.csv.Error(); err != nil {
+			return err
+		}
+	case FormatJSONL:
+	default:
+		return fmt.Errorf("relations cannot be streamed as %s", format)
+	}
+
+	r.sinks = append(r.sinks, s)
+	return nil
+}
+
+// addOutputs creates the files for the program's output directives.
+func (r *Runner) addOutputs(p *ast.Program) error {
+	for _, st := range p.Statements {
+		if st.Output == nil {
+			continue
+		}
+		o := st.Output
+
+		var format Format
+		switch filepath.Ext(o.File()) {
+		case ".csv":
+			format = FormatCSV
+		case ".tsv":
+			format = FormatTSV
+		case ".jsonl":
+			format = FormatJSONL
+		default:
+			return newSemanticError(fmt.Sprintf("%s: unknown file type (expected .csv, .tsv or .jsonl)", o.File()), o.Pos)
+		}
+		if _, ok := r.relations[o.Name]; !ok {
+			return newSemanticError(fmt.Sprintf("the output relation %q does not exist", o.Name), o.Pos)
+		}
+
+		f, err := os.Create(o.File())
+		if err != nil {
+			return newSemanticError(err.Error(), o.Pos)
+		}
+		if err := r.AddSink(o.Name, f, format); err != nil {
+			f.Close()
+			return newSemanticError(err.Error(), o.Pos)
+		}
+		r.sinks[len(r.sinks)-1].file = f
+	}
+	return nil
+}
+
+// emit writes a newly derived fact to the relation's sinks and passes it to any callbacks
+// registered with OnDerive. Only the first error is kept, and reported by Close.
+func (r *Runner) emit(rel *Relation, f *fact) {
+	for _, cb := range r.callbacks {
+		if cb.rel == nil || cb.rel == rel {
+			cb.fn(rel.exportFact(f))
+		}
+	}
+
+	for _, s := range r.sinks {
+		if s.rel != rel {
+			continue
+		}
+		if err := s.write(f); err != nil && r.sinkErr == nil {
+			r.sinkErr = fmt.Errorf("unable to write %s to its output: %v", rel.id, err)
+		}
+	}
+}
+
+// Close closes the files created for output directives, returning the first error encountered
+// while writing to any sink.
+func (r *Runner) Close() error {
+	err := r.sinkErr
+	for _, s := range r.sinks {
+		if s.file == nil {
+			continue
+		}
+		if cerr := s.file.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	r.sinks = nil
+	return err
+}
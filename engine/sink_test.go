@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rithvikp/dedalus/ast"
+)
+
+func TestSinks(t *testing.T) {
+	const program = `
+peer("L2").
+edge("a","b",L1,0).
+edge("b","c",L1,0).
+path(x,y,l,t) :- edge(x,y,l,t)
+path(x,z,l,t) :- edge(x,y,l,t), path(y,z,l,t)
+Got(x,y,l',t') :- path(x,y,l,t), peer(l'), choose((x,y),t')
+`
+
+	tests := []struct {
+		msg      string
+		relation string
+		format   Format
+		// The header, followed by the facts in any order.
+		header string
+		lines  []string
+	}{
+		{
+			msg:      "csv",
+			relation: "path",
+			format:   FormatCSV,
+			header:   "A0,A1,loc,time",
+			lines:    []string{"a,b,L1,0", "a,c,L1,0", "b,c,L1,0"},
+		},
+		{
+			// Only derived facts are streamed, not those carried forward by persistence.
+			msg:      "jsonl",
+			relation: "Got",
+			format:   FormatJSONL,
+			lines: []string{
+				`{"relation":"Got","A0":"a","A1":"b","loc":"L2","time":1}`,
+				`{"relation":"Got","A0":"a","A1":"c","loc":"L2","time":1}`,
+				`{"relation":"Got","A0":"b","A1":"c","loc":"L2","time":1}`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			p, err := ast.Parse(strings.NewReader(program))
+			if err != nil {
+				t.Errorf("unable to parse the program: %v", err)
+				return
+			}
+
+			r, err := NewRunner(p)
+			if err != nil {
+				t.Errorf("unable to initialize the runner: %v", err)
+				return
+			}
+			r.SetDerivationLog(nil)
+			r.SetNetworkModel(FixedDelay(1))
+
+			var buf bytes.Buffer
+			if err := r.AddSink(tt.relation, &buf, tt.format); err != nil {
+				t.Errorf("unable to add the sink: %v", err)
+				return
+			}
+			for i := 0; i < 3; i++ {
+				r.Step()
+			}
+			if err := r.Close(); err != nil {
+				t.Errorf("unable to close the runner: %v", err)
+				return
+			}
+
+			lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			if tt.header != "" {
+				if lines[0] != tt.header {
+					t.Errorf("got the header %q, want %q", lines[0], tt.header)
+				}
+				lines = lines[1:]
+			}
+			sort.Strings(lines)
+			if diff := cmp.Diff(lines, tt.lines); diff != "" {
+				t.Errorf("output diff (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOutputDirectives(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "paths.tsv")
+	program := `
+.output path("` + out + `")
+edge("a","b",L1,0).
+path(x,y,l,t) :- edge(x,y,l,t)
+`
+
+	p, err := ast.Parse(strings.NewReader(program))
+	if err != nil {
+		t.Errorf("unable to parse the program: %v", err)
+		return
+	}
+	r, err := NewRunner(p)
+	if err != nil {
+		t.Errorf("unable to initialize the runner: %v", err)
+		return
+	}
+	r.SetDerivationLog(nil)
+	r.Step()
+	if err := r.Close(); err != nil {
+		t.Errorf("unable to close the runner: %v", err)
+		return
+	}
+
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Errorf("unable to read the output: %v", err)
+		return
+	}
+	if diff := cmp.Diff(string(b), "A0\tA1\tloc\ttime\na\tb\tL1\t0\n"); diff != "" {
+		t.Errorf("output diff (-got, +want):\n%s", diff)
+	}
+
+	p, err = ast.Parse(strings.NewReader(`.output missing("` + out + `")`))
+	if err != nil {
+		t.Errorf("unable to parse the program: %v", err)
+		return
+	}
+	if _, err := NewRunner(p); err == nil {
+		t.Errorf("expected an error for an output relation which does not exist")
+	}
+}
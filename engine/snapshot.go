@@ -0,0 +1,106 @@
+package engine
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// snapshot is a copy of the mutable state of a Runner. Facts are never modified once derived, so
+// snapshots share them with the runner instead of copying them.
+type snapshot struct {
+	facts     map[*Relation][]*fact
+	locations []string
+	timestamp int
+
+	faults     []Fault
+	recovered  map[string]map[*Relation][]*fact
+	partitions []*Partition
+	held       []heldDerivation
+}
+
+func (r *Runner) snapshot() *snapshot {
+	s := &snapshot{
+		facts:      map[*Relation][]*fact{},
+		locations:  r.sortedLocations(),
+		timestamp:  r.currentTimestamp,
+		faults:     slices.Clone(r.faults),
+		recovered:  copyRecovered(r.recovered),
+		partitions: slices.Clone(r.partitions),
+		held:       slices.Clone(r.held),
+	}
+	for _, rel := range r.relations {
+		s.facts[rel] = rel.allAcrossSpaceTime()
+	}
+	return s
+}
+
+// restore replaces the runner's state with the given snapshot.
+func (r *Runner) restore(s *snapshot) {
+	for rel, facts := range s.facts {
+		rel.clear()
+		for _, f := range facts {
+			rel.index(f)
+		}
+	}
+
+	r.locations = map[string]struct{}{}
+	for _, loc := range s.locations {
+		r.locations[loc] = struct{}{}
+	}
+	r.currentTimestamp = s.timestamp
+	r.faults = slices.Clone(s.faults)
+	r.recovered = copyRecovered(s.recovered)
+	r.partitions = slices.Clone(s.partitions)
+	r.held = slices.Clone(s.held)
+}
+
+// copyRecovered copies the map of recovered facts, which are themselves never modified.
+func copyRecovered(recovered map[string]map[*Relation][]*fact) map[string]map[*Relation][]*fact {
+	if recovered == nil {
+		return nil
+	}
+	c := make(map[string]map[*Relation][]*fact, len(recovered))
+	for loc, saved := range recovered {
+		c[loc] = saved
+	}
+	return c
+}
+
+// signature summarizes every fact which can still influence execution (those at or after the
+// current timestep, along with held and recovered facts), so that identical states reached through different schedules can be detected.
+func (r *Runner) signature() string {
+	var lines []string
+	for _, rel := range r.relations {
+		if rel.readOnly {
+			continue
+		}
+		for _, f := range rel.allAcrossSpaceTime() {
+			if f.timestamp < r.currentTimestamp {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%s(%s)@%s:%d", rel.id, strings.Join(valueStrings(f.data), ","), f.location, f.timestamp))
+		}
+	}
+	for _, h := range r.held {
+		lines = append(lines, fmt.Sprintf("held %s(%s)@%s->%s:%d", h.d.rule.head.id, strings.Join(valueStrings(h.d.data), ","), h.d.from, h.d.to, h.partition.End))
+	}
+	for loc, saved := range r.recovered {
+		for rel, facts := range saved {
+			for _, f := range facts {
+				lines = append(lines, fmt.Sprintf("recovered %s(%s)@%s", rel.id, strings.Join(valueStrings(f.data), ","), loc))
+			}
+		}
+	}
+	sort.Strings(lines)
+
+	h := sha1.New()
+	for _, l := range lines {
+		h.Write([]byte(l))
+		h.Write([]byte{'\n'})
+	}
+	return string(h.Sum(nil))
+}
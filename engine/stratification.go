@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+)
+
+// stratify assigns every relation (and every rule deriving facts in the same timestep) to a
+// stratum such that a relation is only negated or aggregated over once it has been fully computed.
+// Only rules whose head is at the same timestep as their body create dependencies: successor and
+// async rules deliver into the future, so the relations they read are always complete by the time
+// they run.
+//
+// Strata are computed with the standard iterative approach: stratum(head) >= stratum(body) for
+// every positive edge, and stratum(head) > stratum(body) for every negative or aggregation edge. If
+// a stratum ever exceeds the number of relations, there is a cycle through negation/aggregation
+// and the program is rejected.
+func (s *State) stratify() error {
+	for _, rel := range s.relations {
+		rel.stratum = 0
+	}
+
+	limit := len(s.relations)
+	for changed := true; changed; {
+		changed = false
+		for _, rl := range s.rules {
+			if rl.timeModel != TimeModelSame {
+				continue
+			}
+
+			raise := func(body *Relation, negative bool) error {
+				min := body.stratum
+				if negative {
+					min++
+				}
+				if rl.head.stratum >= min {
+					return nil
+				}
+
+				rl.head.stratum = min
+				changed = true
+				if min > limit {
+					return newSemanticError(fmt.Sprintf("the program is not stratifiable: %q depends on itself through negation or aggregation", rl.head.id), rl.pos)
+				}
+				return nil
+			}
+
+			for _, rel := range rl.body {
+				if err := raise(rel, rl.hasAggregation); err != nil {
+					return err
+				}
+			}
+			for _, rel := range rl.negatedBody {
+				if err := raise(rel, true); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	s.strata = nil
+	s.temporalRules = nil
+	for _, rl := range s.rules {
+		if rl.timeModel != TimeModelSame {
+			s.temporalRules = append(s.temporalRules, rl)
+			continue
+		}
+
+		for len(s.strata) <= rl.head.stratum {
+			s.strata = append(s.strata, nil)
+		}
+		s.strata[rl.head.stratum] = append(s.strata[rl.head.stratum], rl)
+	}
+
+	return nil
+}
+
+// Strata returns the rules which derive facts within a timestep, grouped by stratum (in evaluation
+// order).
+func (s *State) Strata() [][]*Rule {
+	return s.strata
+}
+
+// sortedLocations returns a deterministic snapshot of the known locations.
+func (s *State) sortedLocations() []string {
+	locs := make([]string, 0, len(s.locations))
+	for loc := range s.locations {
+		locs = append(locs, loc)
+	}
+	sort.Strings(locs)
+	return locs
+}
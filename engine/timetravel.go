@@ -0,0 +1,182 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// frame is the state of a runner at the start of a timestep which executing that timestep can
+// change. Facts at earlier timesteps never change once a timestep begins, so only the facts at or
+// after it (and read-only facts) are kept, shared with the runner as in snapshots.
+type frame struct {
+	timestamp int
+	facts     map[*Relation][]*fact
+	locations []string
+
+	faults     []Fault
+	recovered  map[string]map[*Relation][]*fact
+	partitions []*Partition
+	held       []heldDerivation
+}
+
+// SetHistory sets how many of the most recently executed timesteps can be rewound to (see Rewind).
+// No history is kept by default, since recording a timestep copies every fact at or after it.
+func (r *Runner) SetHistory(n int) {
+	if n < 0 {
+		n = 0
+	}
+	r.historyLimit = n
+	if len(r.history) > n {
+		r.history = slices.Clone(r.history[len(r.history)-n:])
+	}
+}
+
+// record saves the frame for the current timestep, which is about to be executed, discarding the
+// oldest frame if the history is full.
+func (r *Runner) record() {
+	if r.historyLimit == 0 {
+		return
+	}
+	f := &frame{
+		timestamp:  r.currentTimestamp,
+		facts:      map[*Relation][]*fact{},
+		locations:  r.sortedLocations(),
+		faults:     slices.Clone(r.faults),
+		partitions: slices.Clone(r.partitions),
+		held:       slices.Clone(r.held),
+		recovered:  copyRecovered(r.recovered),
+	}
+	for _, rel := range r.relations {
+		if facts := rel.since(r.currentTimestamp); len(facts) > 0 {
+			f.facts[rel] = facts
+		}
+	}
+
+	if len(r.history) == r.historyLimit {
+		copy(r.history, r.history[1:])
+		r.history = r.history[:len(r.history)-1]
+	}
+	r.history = append(r.history, f)
+}
+
+// Rewind returns the runner to the start of an earlier timestep within its history (see
+// SetHistory), discarding everything which happened since (including facts inserted and faults or
+// partitions added in the meantime), so that it can be executed again, for example with a
+// different seed. Facts which were streamed to sinks or callbacks are not retracted, and are
+// reported again if they are derived again.
+func (r *Runner) Rewind(time int) error {
+	if r.scheduler != nil {
+		return errors.New("timesteps cannot be rewound once locations have their own clocks")
+	} else if time == r.currentTimestamp {
+		return nil
+	} else if time > r.currentTimestamp {
+		return fmt.Errorf("timestep %d has not been executed yet", time)
+	} else if r.historyLimit == 0 {
+		return errors.New("no history is kept (see SetHistory)")
+	} else if len(r.history) == 0 || time < r.history[0].timestamp {
+		return fmt.Errorf("the history of timestep %d is not available", time)
+	}
+	i := time - r.history[0].timestamp
+	f := r.history[i]
+
+	for _, rel := range r.relations {
+		if rel.readOnly {
+			rel.clear()
+		} else {
+			rel.removeIf(func(fa *fact) bool {
+				return fa.timestamp >= time
+			})
+		}
+		for _, fa := range f.facts[rel] {
+			rel.index(fa)
+		}
+	}
+
+	r.locations = map[string]struct{}{}
+	for _, loc := range f.locations {
+		r.locations[loc] = struct{}{}
+	}
+	r.currentTimestamp = f.timestamp
+	r.faults = slices.Clone(f.faults)
+	r.partitions = slices.Clone(f.partitions)
+	r.held = slices.Clone(f.held)
+	r.recovered = copyRecovered(f.recovered)
+
+	// The frame is recorded again when the timestep is executed.
+	r.history = r.history[:i]
+	return nil
+}
+
+// FactsAt returns the facts in every located relation at the given timestep, sorted by relation,
+// location and then attributes. An empty location matches every location.
+func (r *Runner) FactsAt(loc string, time int) []Fact {
+	var facts []Fact
+	for _, name := range r.Relations() {
+		if r.relations[name].readOnly {
+			continue
+		}
+		// The relation exists and the pattern is nil, so this cannot fail.
+		r.EachFact(name, nil, loc, time, func(f Fact) bool {
+			facts = append(facts, f)
+			return true
+		})
+	}
+	return facts
+}
+
+// RelationDiff is the change in a relation between two timesteps.
+type RelationDiff struct {
+	Relation string
+	// Facts which hold at the later timestep but not the earlier one, and vice versa.
+	Added   []Fact
+	Removed []Fact
+}
+
+// Diff compares the facts in every located relation at two timesteps, ignoring their timestamps,
+// and returns the relations which changed, sorted by name. An empty location compares every
+// location.
+func (r *Runner) Diff(loc string, from, to int) []RelationDiff {
+	key := func(f Fact) string {
+		return f.Relation + "\x00" + f.Location + "\x00" + strings.Join(f.Tuple, "\x00")
+	}
+	holds := func(facts []Fact) map[string]bool {
+		m := map[string]bool{}
+		for _, f := range facts {
+			m[key(f)] = true
+		}
+		return m
+	}
+	before, after := r.FactsAt(loc, from), r.FactsAt(loc, to)
+	heldBefore, heldAfter := holds(before), holds(after)
+
+	diffs := map[string]*RelationDiff{}
+	diff := func(relation string) *RelationDiff {
+		if _, ok := diffs[relation]; !ok {
+			diffs[relation] = &RelationDiff{Relation: relation}
+		}
+		return diffs[relation]
+	}
+	for _, f := range after {
+		if !heldBefore[key(f)] {
+			d := diff(f.Relation)
+			d.Added = append(d.Added, f)
+		}
+	}
+	for _, f := range before {
+		if !heldAfter[key(f)] {
+			d := diff(f.Relation)
+			d.Removed = append(d.Removed, f)
+		}
+	}
+
+	var res []RelationDiff
+	for _, name := range r.Relations() {
+		if d, ok := diffs[name]; ok {
+			res = append(res, *d)
+		}
+	}
+	return res
+}
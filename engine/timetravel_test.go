@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rithvikp/dedalus/ast"
+)
+
+func TestTimeTravel(t *testing.T) {
+	// A pings B every timestep, and B remembers every ping.
+	const program = `
+peer("B").
+Src("x",A,0).
+ping(v,t,l',t') :- Src(v,l,t), peer(l'), choose((v,t),t')
+Got(v,s,l,t) :- ping(v,s,l,t)
+`
+
+	newTestRunner := func(t *testing.T, m NetworkModel) *Runner {
+		t.Helper()
+		p, err := ast.Parse(strings.NewReader(program))
+		if err != nil {
+			t.Fatalf("unable to parse the program: %v", err)
+		}
+		r, err := NewRunner(p)
+		if err != nil {
+			t.Fatalf("unable to initialize the runner: %v", err)
+		}
+		r.SetDerivationLog(nil)
+		r.SetNetworkModel(m)
+		return r
+	}
+	relations := func(t *testing.T, r *Runner) string {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := r.WriteRelations(&buf, FormatJSONL); err != nil {
+			t.Fatalf("unable to write the relations: %v", err)
+		}
+		return buf.String()
+	}
+
+	t.Run("view and diff", func(t *testing.T) {
+		r := newTestRunner(t, FixedDelay(1))
+		for i := 0; i < 3; i++ {
+			r.Step()
+		}
+
+		wantFacts := []Fact{
+			{Relation: "Got", Tuple: []string{"x", "0"}, Location: "B", Timestamp: 1},
+			{Relation: "ping", Tuple: []string{"x", "0"}, Location: "B", Timestamp: 1},
+		}
+		if diff := cmp.Diff(wantFacts, r.FactsAt("B", 1)); diff != "" {
+			t.Errorf("unexpected facts at B at timestep 1 (-want +got):\n%s", diff)
+		}
+
+		wantDiff := []RelationDiff{
+			{Relation: "Got", Added: []Fact{{Relation: "Got", Tuple: []string{"x", "1"}, Location: "B", Timestamp: 2}}},
+			{
+				Relation: "ping",
+				Added:    []Fact{{Relation: "ping", Tuple: []string{"x", "1"}, Location: "B", Timestamp: 2}},
+				Removed:  []Fact{{Relation: "ping", Tuple: []string{"x", "0"}, Location: "B", Timestamp: 1}},
+			},
+		}
+		if diff := cmp.Diff(wantDiff, r.Diff("", 1, 2)); diff != "" {
+			t.Errorf("unexpected diff between timesteps 1 and 2 (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("rewind", func(t *testing.T) {
+		r := newTestRunner(t, UniformDelay{Min: 1, Max: 4})
+		r.SetSeed(1)
+		r.SetHistory(8)
+		for i := 0; i < 8; i++ {
+			r.Step()
+		}
+		want := relations(t, r)
+		atThree := r.FactsAt("", 3)
+
+		if err := r.Rewind(3); err != nil {
+			t.Fatalf("unable to rewind: %v", err)
+		}
+		if r.CurrentTimestamp() != 3 {
+			t.Errorf("rewound to timestep %d, but expected 3", r.CurrentTimestamp())
+		}
+		if facts, _ := r.Query("Src", nil, "", 4); len(facts) != 0 {
+			t.Errorf("facts persisted by timestep 3 were not discarded: %v", facts)
+		}
+		for i := 3; i < 8; i++ {
+			r.Step()
+		}
+		if diff := cmp.Diff(want, relations(t, r)); diff != "" {
+			t.Errorf("re-executing with the same seed diverged (-original +rewound):\n%s", diff)
+		}
+
+		// Rewinding twice, with a different seed, only changes the timesteps which are re-executed.
+		if err := r.Rewind(3); err != nil {
+			t.Fatalf("unable to rewind again: %v", err)
+		}
+		r.SetSeed(2)
+		for i := 3; i < 8; i++ {
+			r.Step()
+		}
+		if diff := cmp.Diff(atThree, r.FactsAt("", 3)); diff != "" {
+			t.Errorf("timestep 3 changed after rewinding to it (-original +rewound):\n%s", diff)
+		}
+		if got := relations(t, r); got == want {
+			t.Errorf("re-executing with a different seed produced identical relations")
+		}
+
+		if err := r.Rewind(10); err == nil {
+			t.Errorf("expected an error rewinding to a future timestep")
+		}
+	})
+
+	t.Run("bounded history", func(t *testing.T) {
+		r := newTestRunner(t, FixedDelay(1))
+		for i := 0; i < 3; i++ {
+			r.Step()
+		}
+		if err := r.Rewind(1); err == nil {
+			t.Errorf("expected an error rewinding without any history")
+		}
+
+		r.SetHistory(2)
+		for i := 3; i < 8; i++ {
+			r.Step()
+		}
+		if err := r.Rewind(5); err == nil {
+			t.Errorf("expected an error rewinding past the history")
+		}
+		if err := r.Rewind(6); err != nil {
+			t.Errorf("unable to rewind within the history: %v", err)
+		}
+	})
+}
@@ -0,0 +1,311 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"golang.org/x/exp/slices"
+)
+
+// typeNode is an equivalence class of attributes, variables and expressions which must share a
+// type. Classes are merged with a union-find.
+type typeNode struct {
+	parent *typeNode
+
+	// A type required by the program (e.g. location variables or numeric constants).
+	required valueType
+	// A type suggested by literal data, only used if nothing is required.
+	literal valueType
+
+	numeric bool
+	ordered bool
+
+	// A description of the class and the position at which it was first seen, for error messages.
+	desc string
+	pos  lexer.Position
+}
+
+func (n *typeNode) root() *typeNode {
+	for n.parent != nil {
+		if n.parent.parent != nil {
+			n.parent = n.parent.parent
+		}
+		n = n.parent
+	}
+	return n
+}
+
+// unifyTypes returns the narrowest type compatible with both a and b, allowing ints to widen to
+// floats.
+func unifyTypes(a, b valueType) (valueType, bool) {
+	switch {
+	case a == typeUnknown:
+		return b, true
+	case b == typeUnknown || a == b:
+		return a, true
+	case a.numeric() && b.numeric():
+		return typeFloat, true
+	}
+	return typeUnknown, false
+}
+
+// unifyLiteralTypes is like unifyTypes, but falls back to strings instead of failing since literals
+// can always be represented as strings.
+func unifyLiteralTypes(a, b valueType) valueType {
+	if t, ok := unifyTypes(a, b); ok {
+		return t
+	}
+	return typeString
+}
+
+type typeChecker struct {
+	nodes map[interface{}]*typeNode
+	pos   lexer.Position // The position of the rule currently being checked
+}
+
+func (tc *typeChecker) node(k interface{}, desc string) *typeNode {
+	n, ok := tc.nodes[k]
+	if !ok {
+		n = &typeNode{desc: desc, pos: tc.pos}
+		tc.nodes[k] = n
+	}
+	return n.root()
+}
+
+func (tc *typeChecker) require(n *typeNode, t valueType) error {
+	n = n.root()
+	unified, ok := unifyTypes(n.required, t)
+	if !ok {
+		return newSemanticError(fmt.Sprintf("%s is used as both a %s and a %s", n.desc, n.required, t), tc.pos)
+	}
+	n.required = unified
+	return nil
+}
+
+func (tc *typeChecker) union(a, b *typeNode) error {
+	a, b = a.root(), b.root()
+	if a == b {
+		return nil
+	}
+
+	unified, ok := unifyTypes(a.required, b.required)
+	if !ok {
+		return newSemanticError(fmt.Sprintf("%s (a %s) and %s (a %s) must have the same type", a.desc, a.required, b.desc, b.required), tc.pos)
+	}
+
+	b.parent = a
+	if a.pos.Line == 0 {
+		a.pos = b.pos
+	}
+	a.required = unified
+	a.literal = unifyLiteralTypes(a.literal, b.literal)
+	a.numeric = a.numeric || b.numeric
+	a.ordered = a.ordered || b.ordered
+	return nil
+}
+
+func (tc *typeChecker) attrNode(rel *Relation, index int) *typeNode {
+	desc := fmt.Sprintf("attribute %d of %q", index, rel.id)
+	if rel.attrNames != nil {
+		desc = fmt.Sprintf("attribute %q of %q", rel.attrNames[index], rel.id)
+	}
+	return tc.node(Attribute{relation: rel, index: index}, desc)
+}
+
+func (tc *typeChecker) varNode(v *Variable) *typeNode {
+	return tc.node(v, fmt.Sprintf("variable %q", v.id))
+}
+
+func (tc *typeChecker) exprNode(e expression) (*typeNode, error) {
+	switch e := e.(type) {
+	case *Variable:
+		return tc.varNode(e), nil
+	case number:
+		// Numeric constants can be compared with (and widened to) floats.
+		return &typeNode{desc: fmt.Sprintf("constant %d", int(e)), pos: tc.pos, literal: typeInt, numeric: true}, nil
+	case *binOp:
+		n := tc.node(e, "arithmetic expression")
+		n.numeric = true
+		for _, operand := range []expression{e.e1, e.e2} {
+			on, err := tc.exprNode(operand)
+			if err != nil {
+				return nil, err
+			}
+			if err := tc.union(n, on); err != nil {
+				return nil, err
+			}
+		}
+		return n.root(), nil
+	}
+	panic(fmt.Sprintf("Internal Error: unknown expression type %T", e))
+}
+
+func (tc *typeChecker) checkRule(rl *Rule) error {
+	tc.pos = rl.pos
+
+	for j, ht := range rl.headVarMapping {
+		a := tc.attrNode(rl.head, j)
+		v := tc.varNode(ht.v)
+		if ht.agg == nil {
+			if err := tc.union(a, v); err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch *ht.agg {
+		case aggregatorCount:
+			if err := tc.require(a, typeInt); err != nil {
+				return err
+			}
+			continue
+		case aggregatorSum:
+			v.numeric = true
+		case aggregatorMax, aggregatorMin:
+			v.ordered = true
+		}
+		if err := tc.union(a, v); err != nil {
+			return err
+		}
+	}
+
+	for _, rel := range append(slices.Clone(rl.body), rl.negatedBody...) {
+		for j, v := range rl.vars[rel.id] {
+			if err := tc.union(tc.attrNode(rel, j), tc.varNode(v)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, v := range []*Variable{rl.headLocVar, rl.bodyLocVar} {
+		if v != nil {
+			if err := tc.require(tc.varNode(v), typeLocation); err != nil {
+				return err
+			}
+		}
+	}
+	for _, v := range []*Variable{rl.headTimeVar, rl.bodyTimeVar} {
+		if v != nil {
+			if err := tc.require(tc.varNode(v), typeInt); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, a := range rl.assignments {
+		e, err := tc.exprNode(a.e)
+		if err != nil {
+			return err
+		}
+		if err := tc.union(tc.varNode(a.v), e); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range rl.conditions {
+		e1, err := tc.exprNode(c.e1)
+		if err != nil {
+			return err
+		}
+		e2, err := tc.exprNode(c.e2)
+		if err != nil {
+			return err
+		}
+		if err := tc.union(e1, e2); err != nil {
+			return err
+		}
+		if c.op != "=" && c.op != "!=" {
+			e1.root().ordered = true
+		}
+	}
+
+	return nil
+}
+
+// resolve picks the final type for an equivalence class.
+func (tc *typeChecker) resolve(n *typeNode) (valueType, error) {
+	n = n.root()
+	t := n.required
+	if t == typeUnknown {
+		t = n.literal
+		if n.numeric && !t.numeric() || n.ordered && !t.ordered() {
+			t = typeUnknown
+		}
+	}
+
+	if t == typeUnknown {
+		if n.numeric || n.ordered {
+			t = typeInt
+		} else {
+			t = typeString
+		}
+	}
+
+	if n.numeric && !t.numeric() {
+		return typeUnknown, newSemanticError(fmt.Sprintf("%s must be numeric, but is a %s", n.desc, t), n.pos)
+	} else if n.ordered && !t.ordered() {
+		return typeUnknown, newSemanticError(fmt.Sprintf("%s must be comparable with <, <=, > and >=, but is a %s", n.desc, t), n.pos)
+	}
+	return t, nil
+}
+
+// typeCheck infers the type of every attribute in the program. Attributes which are connected by a
+// shared variable (or compared in a condition) must have compatible types, location and time
+// variables have fixed types, and arithmetic and some aggregations require numbers. Attributes which
+// are not otherwise constrained take the type suggested by their literal data.
+func (s *State) typeCheck() error {
+	tc := &typeChecker{nodes: map[interface{}]*typeNode{}}
+	for _, rel := range s.relations {
+		for i, t := range rel.literalTypes {
+			tc.attrNode(rel, i).literal = t
+		}
+		for i, t := range rel.declaredTypes {
+			tc.attrNode(rel, i).required = t
+		}
+	}
+
+	for _, rl := range s.rules {
+		if err := tc.checkRule(rl); err != nil {
+			return err
+		}
+	}
+
+	for _, rel := range s.relations {
+		types := make([]valueType, rel.numAttrs())
+		for i := range types {
+			n := tc.attrNode(rel, i)
+			t, err := tc.resolve(n)
+			if err != nil {
+				return err
+			}
+			// Declared types are required, but unification may still have widened them.
+			if rel.declaredTypes != nil && t != rel.declaredTypes[i] {
+				return newSemanticError(fmt.Sprintf("%s is declared as a %s, but is used as a %s", n.desc, rel.declaredTypes[i], t), n.pos)
+			}
+			types[i] = t
+		}
+
+		if rel.types != nil && len(rel.ltIndex) > 0 {
+			for i := range types {
+				if types[i] != rel.types[i] {
+					return newSemanticError(fmt.Sprintf("attribute %d of %q, which already has facts, would change from a %s to a %s", i, rel.id, rel.types[i], types[i]), tc.pos)
+				}
+			}
+		}
+		rel.types = types
+	}
+
+	return nil
+}
+
+// checkQuery type checks a rule against the resolved types of every relation it reads, without
+// changing them.
+func (s *State) checkQuery(rl *Rule) error {
+	tc := &typeChecker{nodes: map[interface{}]*typeNode{}}
+	for _, rel := range s.relations {
+		for i, t := range rel.types {
+			tc.attrNode(rel, i).required = t
+		}
+	}
+	return tc.checkRule(rl)
+}
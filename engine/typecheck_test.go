@@ -0,0 +1,244 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rithvikp/dedalus/ast"
+)
+
+func TestTypeCheck(t *testing.T) {
+	tests := []struct {
+		msg    string
+		source string
+		types  map[string][]valueType
+		valid  bool
+	}{
+		{
+			msg: "types from literals and usage",
+			source: `
+out(a,b,c,l',t) :- in(a,b,l,t), peer(l'), c=a+1
+in("1","x",L1,0).
+peer("L2").`,
+			types: map[string][]valueType{
+				"in":   {typeInt, typeString},
+				"peer": {typeLocation},
+				"out":  {typeInt, typeString, typeInt},
+			},
+			valid: true,
+		},
+		{
+			msg: "ints widen to floats",
+			source: `
+out(a,l,t) :- in1(a,l,t), in2(a,l,t)
+in1("1",L1,0).
+in2("1.5",L1,0).`,
+			types: map[string][]valueType{
+				"in1": {typeFloat},
+				"in2": {typeFloat},
+				"out": {typeFloat},
+			},
+			valid: true,
+		},
+		{
+			msg: "literals which do not read as numbers",
+			source: `
+out(a,b,c,d,l,t) :- in(a,b,c,d,l,t)
+in("007","nan","Inf","2.50",L1,0).`,
+			types: map[string][]valueType{
+				"in":  {typeString, typeString, typeString, typeFloat},
+				"out": {typeString, typeString, typeString, typeFloat},
+			},
+			valid: true,
+		},
+		{
+			msg: "count is always an int",
+			source: `
+out(count<a>,l,t) :- in(a,l,t)
+in("x",L1,0).`,
+			types: map[string][]valueType{
+				"in":  {typeString},
+				"out": {typeInt},
+			},
+			valid: true,
+		},
+		{
+			msg: "arithmetic on a string",
+			source: `
+out(b,l,t) :- in(a,l,t), b=a+1
+in("x",L1,0).`,
+		},
+		{
+			msg: "location used as a number",
+			source: `
+out(b,l,t) :- in(a,l,t), b=l+1`,
+		},
+		{
+			msg: "location joined with a time",
+			source: `
+out(a,l,t) :- in(a,a,l,t), a=l, a=t`,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			p, err := ast.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Errorf("unable to parse the program: %v", err)
+				return
+			}
+
+			s, err := New(p)
+			if !tt.valid {
+				if _, ok := err.(*SemanticError); !ok {
+					t.Errorf("expected a semantic error, got %v", err)
+				}
+				return
+			} else if err != nil {
+				t.Errorf("unable to initialize the engine state: %v", err)
+				return
+			}
+
+			for rel, want := range tt.types {
+				if diff := cmp.Diff(s.relations[rel].types, want); diff != "" {
+					t.Errorf("type diff for relation %q (-got, +want):\n%s", rel, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestDeclarations(t *testing.T) {
+	tests := []struct {
+		msg    string
+		source string
+		types  map[string][]valueType
+		attrs  map[string][]string
+		valid  bool
+	}{
+		{
+			msg: "declared types and names",
+			source: `
+.decl in(id: int, name: string)
+.decl Out(name: string) located
+out(b,l,t) :- in(a,b,l,t)
+in("1","x",L1,0).`,
+			types: map[string][]valueType{
+				"in": {typeInt, typeString},
+			},
+			attrs: map[string][]string{
+				"in": {"in.id", "in.name"},
+			},
+			valid: true,
+		},
+		{
+			msg: "declared types override literals",
+			source: `
+.decl in(a: string)
+out(a,l,t) :- in(a,l,t)
+in("1",L1,0).`,
+			types: map[string][]valueType{
+				"in":  {typeString},
+				"out": {typeString},
+			},
+			valid: true,
+		},
+		{
+			msg: "edb declaration",
+			source: `
+.decl peer(node: location) edb
+out(a,l',t) :- in(a,l,t), peer(l')
+peer("L2").`,
+			types: map[string][]valueType{
+				"peer": {typeLocation},
+			},
+			valid: true,
+		},
+		{
+			msg: "wrong number of terms",
+			source: `
+.decl in(a: int, b: int)
+out(a,l,t) :- in(a,l,t)`,
+		},
+		{
+			msg: "located preload for an edb",
+			source: `
+.decl peer(node: location) edb
+peer("L2",L1,0).`,
+		},
+		{
+			msg: "preload of the wrong type",
+			source: `
+.decl in(a: int)
+in("x",L1,0).`,
+		},
+		{
+			msg: "conflicting usage",
+			source: `
+.decl in(a: string)
+out(b,l,t) :- in(a,l,t), b=a+1`,
+		},
+		{
+			msg: "declared int widened to a float",
+			source: `
+.decl a(x: int)
+.decl b(y: float)
+a(x,l,t) :- b(x,l,t)`,
+		},
+		{
+			msg: "reserved attribute name",
+			source: `
+.decl in(loc: string)`,
+		},
+		{
+			msg: "unknown type",
+			source: `
+.decl in(a: integer)`,
+		},
+		{
+			msg: "head of an edb",
+			source: `
+.decl peer(node: location) edb
+peer(l,l,t) :- in(l,l,t)`,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			p, err := ast.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Errorf("unable to parse the program: %v", err)
+				return
+			}
+
+			s, err := New(p)
+			if !tt.valid {
+				if _, ok := err.(*SemanticError); !ok {
+					t.Errorf("expected a semantic error, got %v", err)
+				}
+				return
+			} else if err != nil {
+				t.Errorf("unable to initialize the engine state: %v", err)
+				return
+			}
+
+			for rel, want := range tt.types {
+				if diff := cmp.Diff(s.relations[rel].types, want); diff != "" {
+					t.Errorf("type diff for relation %q (-got, +want):\n%s", rel, diff)
+				}
+			}
+			for rel, want := range tt.attrs {
+				var got []string
+				for _, a := range s.relations[rel].Attrs() {
+					got = append(got, a.String())
+				}
+				if diff := cmp.Diff(got, want); diff != "" {
+					t.Errorf("attribute diff for relation %q (-got, +want):\n%s", rel, diff)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// valueType is the type of a single attribute (or variable) in a program.
+type valueType int
+
+const (
+	typeUnknown valueType = iota
+	typeInt
+	typeFloat
+	typeString
+	typeBool
+	typeLocation
+)
+
+func (t valueType) String() string {
+	switch t {
+	case typeInt:
+		return "int"
+	case typeFloat:
+		return "float"
+	case typeString:
+		return "string"
+	case typeBool:
+		return "bool"
+	case typeLocation:
+		return "location"
+	default:
+		return "unknown"
+	}
+}
+
+// parseValueType converts the name of a type in a declaration into a valueType.
+func parseValueType(name string) (valueType, bool) {
+	for _, t := range []valueType{typeInt, typeFloat, typeString, typeBool, typeLocation} {
+		if t.String() == name {
+			return t, true
+		}
+	}
+	return typeUnknown, false
+}
+
+func (t valueType) numeric() bool {
+	return t == typeInt || t == typeFloat
+}
+
+// ordered reports whether values of this type can be compared with <, <=, > and >=.
+func (t valueType) ordered() bool {
+	return t.numeric() || t == typeString
+}
+
+// value is a single typed datum in a fact. Values are comparable, so they can be used directly as
+// map keys (e.g. in relation indexes).
+type value struct {
+	typ valueType
+	i   int
+	f   float64
+	s   string // Used for both strings and locations
+	b   bool
+}
+
+func intValue(i int) value {
+	return value{typ: typeInt, i: i}
+}
+
+func floatValue(f float64) value {
+	return value{typ: typeFloat, f: f}
+}
+
+func stringValue(s string) value {
+	return value{typ: typeString, s: s}
+}
+
+func boolValue(b bool) value {
+	return value{typ: typeBool, b: b}
+}
+
+func locationValue(loc string) value {
+	return value{typ: typeLocation, s: loc}
+}
+
+func (v value) String() string {
+	switch v.typ {
+	case typeInt:
+		return strconv.Itoa(v.i)
+	case typeFloat:
+		return strconv.FormatFloat(v.f, 'f', -1, 64)
+	case typeBool:
+		return strconv.FormatBool(v.b)
+	default:
+		return v.s
+	}
+}
+
+func valueStrings(vs []value) []string {
+	strs := make([]string, len(vs))
+	for i, v := range vs {
+		strs[i] = v.String()
+	}
+	return strs
+}
+
+// float returns the value as a float, which is only meaningful for numeric values.
+func (v value) float() float64 {
+	if v.typ == typeInt {
+		return float64(v.i)
+	}
+	return v.f
+}
+
+// equal compares two values, treating ints and floats as interchangeable numbers.
+func (v value) equal(other value) bool {
+	if v.typ.numeric() && other.typ.numeric() && v.typ != other.typ {
+		return v.float() == other.float()
+	}
+	return v == other
+}
+
+// compare returns -1, 0 or 1 depending on whether v is less than, equal to or greater than other.
+// Both values must be of ordered types which are compatible with each other.
+func (v value) compare(other value) int {
+	switch {
+	case v.typ == typeInt && other.typ == typeInt:
+		return compareOrdered(v.i, other.i)
+	case v.typ.numeric() && other.typ.numeric():
+		return compareOrdered(v.float(), other.float())
+	case v.typ == typeString && other.typ == typeString:
+		return compareOrdered(v.s, other.s)
+	}
+	panic(fmt.Sprintf("Internal Error: values of type %s and %s cannot be ordered", v.typ, other.typ))
+}
+
+func compareOrdered[T int | float64 | string](a, b T) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// parseValue converts the textual representation of a value into a value of the given type.
+func parseValue(s string, t valueType) (value, error) {
+	switch t {
+	case typeInt:
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return value{}, fmt.Errorf("%q is not an int", s)
+		}
+		return intValue(i), nil
+	case typeFloat:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsNaN(f) {
+			return value{}, fmt.Errorf("%q is not a float", s)
+		}
+		return floatValue(f), nil
+	case typeBool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return value{}, fmt.Errorf("%q is not a bool", s)
+		}
+		return boolValue(b), nil
+	case typeLocation:
+		return locationValue(s), nil
+	default:
+		return stringValue(s), nil
+	}
+}
+
+// floatLiteral matches floats in plain decimal (or scientific) notation, without leading zeros.
+var floatLiteral = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+// literalType returns the most specific type the given literal can be parsed as. This is only used
+// to pick a type for attributes which are otherwise unconstrained by the program, so a literal is
+// only treated as a number if it reads the same as one (e.g. "007" and "nan" remain strings).
+func literalType(s string) valueType {
+	if i, err := strconv.Atoi(s); err == nil && strconv.Itoa(i) == s {
+		return typeInt
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil && floatLiteral.MatchString(s) {
+		return typeFloat
+	}
+	return typeString
+}
+
+// coerce converts a value to the given attribute type, widening ints to floats.
+func coerce(v value, t valueType) value {
+	if t == typeFloat && v.typ == typeInt {
+		return floatValue(float64(v.i))
+	}
+	return v
+}
@@ -0,0 +1,386 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// WhyNotReason is the furthest point a rule reached before failing to derive a fact. Reasons are
+// ordered by how close the rule came to deriving it.
+type WhyNotReason int
+
+const (
+	// The rule reads an earlier timestep than the first one.
+	ReasonNoEarlierTimestep WhyNotReason = iota
+	// The location evaluating the rule was down.
+	ReasonLocationDown
+	// No fact matched a body atom.
+	ReasonMissingFact
+	// A fact matched a negated atom.
+	ReasonNegatedFact
+	// A condition was false.
+	ReasonFailedCondition
+	// The rule derived facts, but none matched.
+	ReasonHeadMismatch
+	// The rule derived a matching fact, but it was dropped or delivered at another timestep.
+	ReasonNotDelivered
+)
+
+func (r WhyNotReason) String() string {
+	switch r {
+	case ReasonNoEarlierTimestep:
+		return "no earlier timestep"
+	case ReasonLocationDown:
+		return "location down"
+	case ReasonMissingFact:
+		return "missing fact"
+	case ReasonNegatedFact:
+		return "negated fact"
+	case ReasonFailedCondition:
+		return "failed condition"
+	case ReasonHeadMismatch:
+		return "head mismatch"
+	default:
+		return "not delivered"
+	}
+}
+
+// RuleExplanation explains why a single rule did not derive a fact.
+type RuleExplanation struct {
+	Rule   *Rule
+	Reason WhyNotReason
+	// Where the rule's body was evaluated. If it could have been evaluated at several locations or
+	// timesteps (e.g. for async rules), this is the one which came closest to deriving the fact.
+	Location  string
+	Timestamp int
+	// The body atom, negated atom or condition responsible, with the values bound at that point.
+	Detail string
+}
+
+// WhyNotExplanation explains why no fact matching a pattern holds.
+type WhyNotExplanation struct {
+	Relation  string
+	Pattern   []string
+	Location  string
+	Timestamp int
+	// One explanation for each rule deriving the relation, in program order.
+	Rules []RuleExplanation
+}
+
+func (e *WhyNotExplanation) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s(%s)", e.Relation, strings.Join(e.Pattern, ", "))
+	if e.Location != "" {
+		fmt.Fprintf(&sb, " @ %s, %d", e.Location, e.Timestamp)
+	}
+	sb.WriteString(" does not hold\n")
+
+	if len(e.Rules) == 0 {
+		fmt.Fprintf(&sb, "  no rule derives %s\n", e.Relation)
+	}
+	for _, re := range e.Rules {
+		fmt.Fprintf(&sb, "  rule %s (line %d)", re.Rule.id, re.Rule.pos.Line)
+		if re.Reason != ReasonNoEarlierTimestep {
+			fmt.Fprintf(&sb, " at %s, %d", re.Location, re.Timestamp)
+		}
+		fmt.Fprintf(&sb, ": %s: %s\n", re.Reason, re.Detail)
+	}
+	return sb.String()
+}
+
+// WhyNot explains why no fact matching the pattern holds at the given location and timestep. The
+// pattern has one entry per attribute, where "_" matches any value. For every rule deriving the
+// relation, the explanation reports the body atom with no matching fact, the negated atom which
+// blocked it or the condition which failed.
+func (r *Runner) WhyNot(relation string, pattern []string, loc string, time int) (*WhyNotExplanation, error) {
+	rel, ok := r.relations[relation]
+	if !ok {
+		return nil, fmt.Errorf("the relation %q does not exist", relation)
+	}
+	if len(pattern) != rel.numAttrs() {
+		return nil, fmt.Errorf("%q has %d attributes, but %d were given", relation, rel.numAttrs(), len(pattern))
+	}
+
+	bound, err := rel.parsePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range rel.all(loc, time) {
+		if matchesPattern(f.data, bound) {
+			return nil, fmt.Errorf("%s(%s) holds at %s at timestep %d", relation, strings.Join(valueStrings(f.data), ", "), loc, time)
+		}
+	}
+
+	e := &WhyNotExplanation{Relation: relation, Pattern: pattern, Location: loc, Timestamp: time}
+	if rel.readOnly {
+		e.Location, e.Timestamp = "", 0
+	}
+	for _, rl := range r.rules {
+		if rl.head == rel {
+			e.Rules = append(e.Rules, r.whyNotRule(rl, bound, loc, time))
+		}
+	}
+	return e, nil
+}
+
+// parsePattern parses one value per attribute of the relation, where "_" (left nil) matches any
+// value.
+func (rel *Relation) parsePattern(pattern []string) ([]*value, error) {
+	bound := make([]*value, len(pattern))
+	for i, s := range pattern {
+		if s == "_" {
+			continue
+		}
+		v, err := parseValue(s, rel.types[i])
+		if err != nil {
+			return nil, fmt.Errorf("attribute %d of %q: %v", i, rel.id, err)
+		}
+		bound[i] = &v
+	}
+	return bound, nil
+}
+
+func matchesPattern(d []value, bound []*value) bool {
+	for i, b := range bound {
+		if b != nil && !d[i].equal(*b) {
+			return false
+		}
+	}
+	return true
+}
+
+// whyNotRule explains why the rule did not derive a fact matching the pattern, considering every
+// location and timestep at which its body could have been evaluated to derive it.
+func (r *Runner) whyNotRule(rl *Rule, bound []*value, loc string, time int) RuleExplanation {
+	locs := r.sortedLocations()
+	if rl.headLocVar == rl.bodyLocVar {
+		locs = []string{loc}
+	}
+
+	var candidates []locTime
+	switch rl.timeModel {
+	case TimeModelSame:
+		for _, l := range locs {
+			candidates = append(candidates, locTime{l, time})
+		}
+	case TimeModelSuccessor:
+		if time > 0 {
+			for _, l := range locs {
+				candidates = append(candidates, locTime{l, time - 1})
+			}
+		}
+	case TimeModelAsync:
+		if time > 0 {
+			candidates = asyncCandidates(rl, locs, time)
+		}
+	}
+	if len(candidates) == 0 {
+		return RuleExplanation{
+			Rule:   rl,
+			Reason: ReasonNoEarlierTimestep,
+			Detail: fmt.Sprintf("the body is evaluated before timestep %d", time),
+		}
+	}
+
+	var best RuleExplanation
+	bestJoined := -1
+	for _, lt := range candidates {
+		e, joined := r.whyNotAt(rl, bound, loc, lt.location, lt.timestamp)
+		if bestJoined < 0 || e.Reason > best.Reason || e.Reason == best.Reason && joined > bestJoined {
+			best, bestJoined = e, joined
+		}
+		if best.Reason == ReasonNotDelivered {
+			break
+		}
+	}
+	return best
+}
+
+// asyncCandidates returns the locations and timesteps before the given one at which an async
+// rule's body could have derived a fact, latest first. A message can be sent at any earlier
+// timestep, but only where every body atom has facts, so only the locations and timesteps of the
+// body relation with the fewest are considered. If there are none, the latest timestep is returned
+// to explain which fact is missing.
+func asyncCandidates(rl *Rule, locs []string, time int) []locTime {
+	var smallest *Relation
+	for _, rel := range rl.body {
+		if !rel.readOnly && (smallest == nil || len(rel.ltIndex) < len(smallest.ltIndex)) {
+			smallest = rel
+		}
+	}
+
+	var candidates []locTime
+	if smallest != nil {
+		evaluated := map[string]bool{}
+		for _, l := range locs {
+			evaluated[l] = true
+		}
+		for lt, n := range smallest.ltIndex {
+			if n > 0 && lt.timestamp < time && evaluated[lt.location] {
+				candidates = append(candidates, lt)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		for _, l := range locs {
+			candidates = append(candidates, locTime{l, time - 1})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].timestamp != candidates[j].timestamp {
+			return candidates[i].timestamp > candidates[j].timestamp
+		}
+		return candidates[i].location < candidates[j].location
+	})
+	return candidates
+}
+
+// whyNotAt joins the rule's body at a single location and timestep, as evaluation does, reporting
+// the step which eliminated every binding. It also returns the number of body atoms joined before
+// that step, since joining more atoms is closer to deriving the fact.
+func (r *Runner) whyNotAt(rl *Rule, bound []*value, loc, bodyLoc string, bodyTime int) (RuleExplanation, int) {
+	e := RuleExplanation{Rule: rl, Location: bodyLoc, Timestamp: bodyTime}
+	if r.down(bodyLoc, bodyTime) {
+		e.Reason = ReasonLocationDown
+		e.Detail = fmt.Sprintf("%s was down", bodyLoc)
+		return e, len(rl.body)
+	}
+
+	// Bind the head variables the pattern constrains, so only body facts which could derive a
+	// matching fact are considered.
+	start := startNode(rl, bodyLoc, bodyTime)
+	assigned := map[*Variable]bool{}
+	for _, a := range rl.assignments {
+		assigned[a.v] = true
+	}
+	bind := func(v *Variable, val value) bool {
+		if cur, ok := start.lockedVars[v]; ok {
+			return cur.equal(val)
+		}
+		start.lockedVars[v] = val
+		return true
+	}
+	consistent := assigned[rl.headLocVar] || bind(rl.headLocVar, locationValue(loc))
+	for j, ht := range rl.headVarMapping {
+		if bound[j] != nil && ht.agg == nil && !assigned[ht.v] {
+			consistent = consistent && bind(ht.v, *bound[j])
+		}
+	}
+	if !consistent {
+		e.Reason = ReasonHeadMismatch
+		e.Detail = fmt.Sprintf("evaluating the body at %s, %d cannot derive a matching fact", bodyLoc, bodyTime)
+		return e, len(rl.body)
+	}
+
+	derivations, failure := joinFrom(rl, rl.body, rl.negatedBody, start, bodyLoc, bodyTime)
+	if failure != nil {
+		e.Reason = failure.reason
+		b := failure.node.lockedVars
+		switch {
+		case failure.reason == ReasonMissingFact:
+			e.Detail = "no fact matches " + describeAtom(rl, rl.body[failure.index], b)
+		case failure.reason == ReasonNegatedFact:
+			e.Detail = "blocked by not " + describeAtom(rl, rl.negatedBody[failure.index], b)
+		case failure.index < 0:
+			e.Detail = "an assignment is undefined (such as a division by zero)"
+		default:
+			e.Detail = describeCondition(rl, rl.conditions[failure.index], b)
+		}
+		return e, failure.joined
+	}
+
+	if rl.hasAggregation {
+		derivations = aggregate(rl, derivations)
+	}
+	for _, d := range derivations {
+		if d.to == loc && matchesPattern(d.data, bound) {
+			e.Reason = ReasonNotDelivered
+			e.Detail = fmt.Sprintf("derived %s(%s) for %s, but it was dropped or delivered at another timestep", rl.head.id, strings.Join(valueStrings(d.data), ", "), d.to)
+			return e, len(rl.body)
+		}
+	}
+
+	d := derivations[0]
+	e.Reason = ReasonHeadMismatch
+	e.Detail = fmt.Sprintf("only derived facts such as %s(%s) for %s", rl.head.id, strings.Join(valueStrings(d.data), ", "), d.to)
+	return e, len(rl.body)
+}
+
+// describeAtom renders a body atom with its bound variables replaced by their values.
+func describeAtom(rl *Rule, rel *Relation, b map[*Variable]value) string {
+	terms := make([]string, len(rl.vars[rel.id]))
+	for j, v := range rl.vars[rel.id] {
+		terms[j] = describeVar(rl, v, b)
+	}
+
+	s := fmt.Sprintf("%s(%s)", rel.id, strings.Join(terms, ", "))
+	if !rel.readOnly {
+		s += fmt.Sprintf(" @ %s, %s", b[rl.bodyLocVar], b[rl.bodyTimeVar])
+	}
+	return s
+}
+
+func describeVar(rl *Rule, v *Variable, b map[*Variable]value) string {
+	if val, ok := b[v]; ok {
+		return val.String()
+	}
+	if v.constant {
+		// Constants are checked by conditions of the form v = n.
+		for _, c := range rl.conditions {
+			if c.e1 == expression(v) {
+				return describeExpr(c.e2)
+			}
+		}
+	}
+	return v.id
+}
+
+// describeCondition renders a condition followed by the values of its variables.
+func describeCondition(rl *Rule, c condition, b map[*Variable]value) string {
+	s := fmt.Sprintf("%s %s %s", describeExpr(c.e1), c.op, describeExpr(c.e2))
+
+	var bindings []string
+	seen := map[*Variable]bool{}
+	var collect func(e expression)
+	collect = func(e expression) {
+		switch e := e.(type) {
+		case *Variable:
+			if !seen[e] {
+				seen[e] = true
+				bindings = append(bindings, fmt.Sprintf("%s = %s", describeExpr(e), b[e]))
+			}
+		case *binOp:
+			collect(e.e1)
+			collect(e.e2)
+		}
+	}
+	collect(c.e1)
+	collect(c.e2)
+
+	if len(bindings) > 0 {
+		s += " with " + strings.Join(bindings, ", ")
+	}
+	return s
+}
+
+// describeExpr renders an expression as it appears in the program, naming constants by the
+// attribute they constrain.
+func describeExpr(e expression) string {
+	switch e := e.(type) {
+	case *Variable:
+		if e.constant {
+			for _, a := range e.Attrs() {
+				return fmt.Sprintf("%s.%s", a.relation.id, a.relation.attrName(a.index))
+			}
+		}
+		return e.id
+	case number:
+		return fmt.Sprint(int(e))
+	case *binOp:
+		return fmt.Sprintf("%s %s %s", describeExpr(e.e1), e.op, describeExpr(e.e2))
+	}
+	return fmt.Sprint(e)
+}
@@ -0,0 +1,186 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rithvikp/dedalus/ast"
+)
+
+func TestWhyNot(t *testing.T) {
+	tests := []struct {
+		msg      string
+		program  string
+		steps    int
+		relation string
+		pattern  []string
+		loc      string
+		time     int
+		want     string
+		err      bool
+	}{
+		{
+			msg: "missing facts",
+			program: `
+edge("a","b",L1,0).
+path(x,y,l,t) :- edge(x,y,l,t)
+path(x,z,l,t) :- edge(x,y,l,t), path(y,z,l,t)
+`,
+			relation: "path",
+			pattern:  []string{"a", "d"},
+			loc:      "L1",
+			want: `path(a, d) @ L1, 0 does not hold
+  rule 0 (line 3) at L1, 0: missing fact: no fact matches edge(a, d) @ L1, 0
+  rule 1 (line 4) at L1, 0: missing fact: no fact matches path(b, d) @ L1, 0
+`,
+		},
+		{
+			msg: "negated atoms",
+			program: `
+req("a",L1,0).
+denied("a",L1,0).
+ok(x,l,t) :- req(x,l,t), not denied(x,l,t)
+`,
+			relation: "ok",
+			pattern:  []string{"_"},
+			loc:      "L1",
+			want: `ok(_) @ L1, 0 does not hold
+  rule 0 (line 4) at L1, 0: negated fact: blocked by not denied(a) @ L1, 0
+`,
+		},
+		{
+			msg: "conditions",
+			program: `
+num("2",L1,0).
+big(x,l,t) :- num(x,l,t), x > 3
+`,
+			relation: "big",
+			pattern:  []string{"2"},
+			loc:      "L1",
+			want: `big(2) @ L1, 0 does not hold
+  rule 0 (line 3) at L1, 0: failed condition: x > 3 with x = 2
+`,
+		},
+		{
+			msg: "constants",
+			program: `
+pair("a","2",L1,0).
+three(x,l,t) :- pair(x,3,l,t)
+`,
+			relation: "three",
+			pattern:  []string{"a"},
+			loc:      "L1",
+			want: `three(a) @ L1, 0 does not hold
+  rule 0 (line 3) at L1, 0: failed condition: pair.A1 = 3 with pair.A1 = 2
+`,
+		},
+		{
+			msg: "undefined assignments",
+			program: `
+in("6","0",L1,0).
+out(a,c,l,t) :- in(a,b,l,t), c=a/b
+`,
+			relation: "out",
+			pattern:  []string{"6", "_"},
+			loc:      "L1",
+			want: `out(6, _) @ L1, 0 does not hold
+  rule 0 (line 3) at L1, 0: failed condition: an assignment is undefined (such as a division by zero)
+`,
+		},
+		{
+			msg: "async rules at later timesteps",
+			program: `
+peer("L2").
+ping("x",L1,0).
+ping("y",L1,1).
+pong(v,l',t') :- ping(v,l,t), peer(l'), choose((v),t')
+`,
+			steps:    3,
+			relation: "pong",
+			pattern:  []string{"x"},
+			loc:      "L2",
+			time:     3,
+			want: `pong(x) @ L2, 3 does not hold
+  rule 0 (line 5) at L1, 0: not delivered: derived pong(x) for L2, but it was dropped or delivered at another timestep
+`,
+		},
+		{
+			msg: "successor rules at the first timestep",
+			program: `
+start("x",L1,0).
+next(v,l,t') :- start(v,l,t), succ(t,t')
+`,
+			relation: "next",
+			pattern:  []string{"x"},
+			loc:      "L1",
+			want: `next(x) @ L1, 0 does not hold
+  rule 0 (line 3): no earlier timestep: the body is evaluated before timestep 0
+`,
+		},
+		{
+			msg: "undelivered async facts",
+			program: `
+peer("L2").
+ping("x",L1,0).
+pong(v,l',t') :- ping(v,l,t), peer(l'), choose((v),t')
+`,
+			steps:    1,
+			relation: "pong",
+			pattern:  []string{"x"},
+			loc:      "L2",
+			time:     1,
+			want: `pong(x) @ L2, 1 does not hold
+  rule 0 (line 4) at L1, 0: not delivered: derived pong(x) for L2, but it was dropped or delivered at another timestep
+`,
+		},
+		{
+			msg: "facts which hold",
+			program: `
+edge("a","b",L1,0).
+`,
+			relation: "edge",
+			pattern:  []string{"a", "_"},
+			loc:      "L1",
+			err:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			p, err := ast.Parse(strings.NewReader(tt.program))
+			if err != nil {
+				t.Errorf("unable to parse the program: %v", err)
+				return
+			}
+
+			r, err := NewRunner(p)
+			if err != nil {
+				t.Errorf("unable to initialize the runner: %v", err)
+				return
+			}
+			r.SetDerivationLog(nil)
+			r.SetNetworkModel(FixedDelay(2))
+			for i := 0; i < tt.steps; i++ {
+				r.Step()
+			}
+			r.evaluate()
+
+			e, err := r.WhyNot(tt.relation, tt.pattern, tt.loc, tt.time)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected an error, but got the explanation:\n%s", e)
+				}
+				return
+			} else if err != nil {
+				t.Errorf("unable to explain the missing fact: %v", err)
+				return
+			}
+
+			if diff := cmp.Diff(e.String(), tt.want); diff != "" {
+				t.Errorf("explanation diff (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}